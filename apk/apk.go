@@ -0,0 +1,296 @@
+// Package apk reads Alpine (and Wolfi) packages in place.
+//
+// An APK is a concatenation of gzip members: an optional signature, the control segment
+// (with .PKGINFO and any install scripts) and the data segment. Each member is a tar,
+// though only the last one has an end-of-archive trailer. [Open] finds the member boundaries
+// with a [gsip.Reader] and indexes each segment as its own [tarfs.FS].
+package apk
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// How much to decompress at a time while looking for the next gzip member.
+const chunkSize = 32 << 10
+
+// Package is an opened APK.
+type Package struct {
+	// Signature is nil for unsigned packages.
+	Signature *tarfs.FS
+	Control   *tarfs.FS
+	Data      *tarfs.FS
+
+	// Info is parsed from the .PKGINFO in Control.
+	Info *PkgInfo
+
+	// Members are the gzip members the segments came from.
+	Members []gsip.Member
+
+	ra io.ReaderAt
+}
+
+// PkgInfo is the metadata from .PKGINFO.
+type PkgInfo struct {
+	Name        string
+	Version     string
+	Arch        string
+	Description string
+	URL         string
+	License     string
+	Origin      string
+	Maintainer  string
+	Commit      string
+	BuildDate   time.Time
+
+	// Size is the installed size.
+	Size int64
+
+	// DataHash is the hex sha256 of the compressed data segment.
+	DataHash string
+
+	Depends  []string
+	Provides []string
+	Replaces []string
+	Triggers []string
+
+	// Fields has every key in the file, including the ones above, in case you need something else.
+	Fields map[string][]string
+}
+
+// ChecksumError is returned when the data segment doesn't match the datahash in .PKGINFO.
+type ChecksumError struct {
+	Want string
+	Got  string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("data segment has sha256 %s, but .PKGINFO says %s", e.Got, e.Want)
+}
+
+// Open indexes the APK in ra, which is size bytes long, and verifies the data segment against .PKGINFO.
+// Packages without a datahash (built by older versions of abuild) aren't verified.
+//
+// opts are passed to [tarfs.New] for each segment.
+func Open(ra io.ReaderAt, size int64, opts ...tarfs.Option) (*Package, error) {
+	zr, err := gsip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Package{ra: ra}
+	s := &scanner{zr: zr, buf: make([]byte, chunkSize)}
+
+	// Find the first segment so we can tell whether it's a signature.
+	members, err := s.scan(2)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) < 2 {
+		return nil, fmt.Errorf("want at least 2 gzip members, found %d", len(members))
+	}
+
+	first, err := segment(zr, members[0], opts)
+	if err != nil {
+		return nil, fmt.Errorf("indexing first segment: %w", err)
+	}
+
+	control, data := 0, 1
+	if signed(first) {
+		p.Signature = first
+
+		if members, err = s.scan(3); err != nil {
+			return nil, err
+		}
+		if len(members) < 3 {
+			return nil, fmt.Errorf("signed package has %d gzip members, want 3", len(members))
+		}
+
+		control, data = 1, 2
+	} else {
+		p.Control = first
+	}
+
+	if p.Control == nil {
+		if p.Control, err = segment(zr, members[control], opts); err != nil {
+			return nil, fmt.Errorf("indexing control segment: %w", err)
+		}
+	}
+
+	if p.Data, err = segment(zr, members[data], opts); err != nil {
+		return nil, fmt.Errorf("indexing data segment: %w", err)
+	}
+
+	// Now that we've read everything, we know where the last member ends.
+	p.Members = zr.Members()
+
+	f, err := p.Control.Open(".PKGINFO")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if p.Info, err = ParsePkgInfo(f); err != nil {
+		return nil, err
+	}
+
+	if p.Info.DataHash != "" {
+		if err := p.Verify(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// scanner decompresses from the front of the stream to discover gzip members.
+//
+// Only the first reader created by gsip.NewReader records new members, so we have to keep using it,
+// which means always reading where we left off.
+type scanner struct {
+	zr  *gsip.Reader
+	off int64
+	buf []byte
+}
+
+// scan reads until it has found n gzip members or hit the end.
+func (s *scanner) scan(n int) ([]gsip.Member, error) {
+	for {
+		if members := s.zr.Index().Members(); len(members) >= n {
+			return members, nil
+		}
+
+		nr, err := s.zr.ReadAt(s.buf, s.off)
+		s.off += int64(nr)
+		if errors.Is(err, io.EOF) {
+			return s.zr.Members(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// segment indexes the tar in one gzip member.
+func segment(zr *gsip.Reader, m gsip.Member, opts []tarfs.Option) (*tarfs.FS, error) {
+	length := m.Decompressed.Length
+	if length < 0 {
+		// The last member runs to the end of the stream.
+		length = 1<<63 - 1 - m.Decompressed.Offset
+	}
+
+	return tarfs.New(io.NewSectionReader(zr, m.Decompressed.Offset, length), m.Decompressed.Length, opts...)
+}
+
+// signed reports whether a segment is a signature, which only has .SIGN.* files.
+func signed(fsys *tarfs.FS) bool {
+	found := false
+	for e := range fsys.Entries() {
+		if !strings.HasPrefix(e.Filename, ".SIGN.") {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// Verify checks the sha256 of the compressed data segment against the datahash in .PKGINFO.
+func (p *Package) Verify() error {
+	if p.Info.DataHash == "" {
+		return errors.New(".PKGINFO has no datahash")
+	}
+
+	data := p.Members[len(p.Members)-1].Compressed
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(p.ra, data.Offset, data.Length)); err != nil {
+		return fmt.Errorf("hashing data segment: %w", err)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != p.Info.DataHash {
+		return &ChecksumError{Want: p.Info.DataHash, Got: got}
+	}
+
+	return nil
+}
+
+// ParsePkgInfo parses the "key = value" lines of a .PKGINFO file.
+func ParsePkgInfo(r io.Reader) (*PkgInfo, error) {
+	info := &PkgInfo{
+		Fields: map[string][]string{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("parsing .PKGINFO: malformed line %q", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		info.Fields[key] = append(info.Fields[key], value)
+
+		switch key {
+		case "pkgname":
+			info.Name = value
+		case "pkgver":
+			info.Version = value
+		case "arch":
+			info.Arch = value
+		case "pkgdesc":
+			info.Description = value
+		case "url":
+			info.URL = value
+		case "license":
+			info.License = value
+		case "origin":
+			info.Origin = value
+		case "maintainer":
+			info.Maintainer = value
+		case "commit":
+			info.Commit = value
+		case "datahash":
+			info.DataHash = value
+		case "depend":
+			info.Depends = append(info.Depends, value)
+		case "provides":
+			info.Provides = append(info.Provides, value)
+		case "replaces":
+			info.Replaces = append(info.Replaces, value)
+		case "triggers":
+			info.Triggers = append(info.Triggers, strings.Fields(value)...)
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing .PKGINFO: size: %w", err)
+			}
+			info.Size = n
+		case "builddate":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing .PKGINFO: builddate: %w", err)
+			}
+			info.BuildDate = time.Unix(n, 0).UTC()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,138 @@
+package apk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// makeSegment returns a gzipped tar of files, without an end-of-archive trailer unless trailer is set.
+func makeSegment(t *testing.T, trailer bool, files ...string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := 0; i < len(files); i += 2 {
+		name, content := files[i], files[i+1]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if trailer {
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	zbuf := &bytes.Buffer{}
+	zw := gzip.NewWriter(zbuf)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return zbuf.Bytes()
+}
+
+func makeAPK(t *testing.T, signed bool, datahash func(data []byte) string) []byte {
+	data := makeSegment(t, true,
+		"usr/bin/hello", strings.Repeat("hello", 10000),
+		"etc/hello.conf", "greeting = hi",
+	)
+
+	pkginfo := fmt.Sprintf(`# Generated by abuild
+pkgname = hello
+pkgver = 1.2.3-r0
+arch = x86_64
+size = 50013
+builddate = 1700000000
+depend = so:libc.musl-x86_64.so.1
+depend = busybox
+triggers = /usr/share/hello/* /etc/hello
+datahash = %s
+`, datahash(data))
+
+	control := makeSegment(t, false, ".PKGINFO", pkginfo, ".post-install", "#!/bin/sh\n")
+
+	var apk []byte
+	if signed {
+		apk = append(apk, makeSegment(t, false, ".SIGN.RSA.key.rsa.pub", "signature")...)
+	}
+	apk = append(apk, control...)
+	apk = append(apk, data...)
+	return apk
+}
+
+func sha(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func TestOpen(t *testing.T) {
+	for _, signed := range []bool{true, false} {
+		t.Run(fmt.Sprintf("signed=%t", signed), func(t *testing.T) {
+			b := makeAPK(t, signed, sha)
+
+			p, err := Open(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := p.Signature != nil; got != signed {
+				t.Errorf("Signature != nil: got %t, want %t", got, signed)
+			}
+			want := 2
+			if signed {
+				want = 3
+			}
+			if len(p.Members) != want {
+				t.Errorf("len(Members): got %d, want %d", len(p.Members), want)
+			}
+
+			if p.Info.Name != "hello" || p.Info.Version != "1.2.3-r0" || p.Info.Size != 50013 {
+				t.Errorf("Info: got %+v", p.Info)
+			}
+			if got := strings.Join(p.Info.Depends, ","); got != "so:libc.musl-x86_64.so.1,busybox" {
+				t.Errorf("Depends: got %q", got)
+			}
+			if len(p.Info.Triggers) != 2 {
+				t.Errorf("Triggers: got %q", p.Info.Triggers)
+			}
+			if p.Info.BuildDate.Unix() != 1700000000 {
+				t.Errorf("BuildDate: got %v", p.Info.BuildDate)
+			}
+
+			if _, err := fs.Stat(p.Control, ".post-install"); err != nil {
+				t.Error(err)
+			}
+			if _, err := fs.Stat(p.Data, ".PKGINFO"); err == nil {
+				t.Errorf("data segment shouldn't have .PKGINFO")
+			}
+
+			got, err := fs.ReadFile(p.Data, "usr/bin/hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Repeat("hello", 10000); string(got) != want {
+				t.Errorf("usr/bin/hello: got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+
+	t.Run("bad datahash", func(t *testing.T) {
+		b := makeAPK(t, true, func([]byte) string { return sha(nil) })
+
+		_, err := Open(bytes.NewReader(b), int64(len(b)))
+		var cerr *ChecksumError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("Open: want ChecksumError, got %v", err)
+		}
+	})
+}
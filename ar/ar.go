@@ -0,0 +1,301 @@
+// Package ar reads Unix ar archives, like Debian packages, with random access to each member.
+package ar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	magic      = "!<arch>\n"
+	headerSize = 60
+)
+
+// ErrHeader is returned for anything that doesn't look like an ar archive.
+var ErrHeader = errors.New("ar: invalid header")
+
+// GNU long name tables bigger than this are garbage.
+const maxNameTable = 16 << 20
+
+// Header is the metadata of an ar member.
+type Header struct {
+	Name    string
+	ModTime time.Time
+	Uid     int
+	Gid     int
+	Mode    int64
+	Size    int64
+}
+
+// Member is an ar member and where it lives in the archive.
+type Member struct {
+	Header
+
+	// HeaderOffset is where the member's header starts.
+	HeaderOffset int64
+
+	// Offset is where the member's data starts (after any BSD-style long name).
+	Offset int64
+}
+
+// Reader reads members of an ar archive at their offsets.
+type Reader struct {
+	ra      io.ReaderAt
+	Members []*Member
+}
+
+// NewReader reads the header of every member of the archive in ra, which is size bytes long.
+//
+// This does one small ReadAt per member and skips over their data, so it's cheap over something like ranger.
+func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
+	buf := make([]byte, headerSize)
+	if _, err := ra.ReadAt(buf[:len(magic)], 0); err != nil {
+		return nil, fmt.Errorf("reading magic: %w", err)
+	}
+	if string(buf[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not an ar archive", ErrHeader)
+	}
+
+	r := &Reader{ra: ra}
+
+	// GNU ar's long name table, once we've seen it.
+	var names []byte
+
+	off := int64(len(magic))
+	for off < size {
+		if _, err := ra.ReadAt(buf, off); err != nil {
+			return nil, fmt.Errorf("reading header at %d: %w", off, err)
+		}
+
+		m, err := parseHeader(buf, off)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := m.longName(io.NewSectionReader(ra, m.Offset, m.Size)); err != nil {
+			return nil, err
+		}
+
+		if m.Name == "//" {
+			if names, err = m.nameTable(io.NewSectionReader(ra, m.Offset, m.Size)); err != nil {
+				return nil, err
+			}
+		} else if err := m.gnuName(names); err != nil {
+			return nil, err
+		}
+
+		r.Members = append(r.Members, m)
+
+		off = m.end()
+	}
+
+	return r, nil
+}
+
+// Member returns the first member named name.
+func (r *Reader) Member(name string) (*Member, error) {
+	for _, m := range r.Members {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("ar: no member named %q", name)
+}
+
+// Open returns the data of the first member named name.
+func (r *Reader) Open(name string) (*io.SectionReader, error) {
+	m, err := r.Member(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Section(m), nil
+}
+
+// Section returns the data of m.
+func (r *Reader) Section(m *Member) *io.SectionReader {
+	return io.NewSectionReader(r.ra, m.Offset, m.Size)
+}
+
+// Index returns a list of ar members with their offsets.
+//
+// Like tarfs.Index, this is useful if you don't have an io.ReaderAt but still want to know the offsets.
+func Index(r io.Reader) ([]*Member, error) {
+	br := bufio.NewReader(r)
+
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, buf[:len(magic)]); err != nil {
+		return nil, fmt.Errorf("reading magic: %w", err)
+	}
+	if string(buf[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not an ar archive", ErrHeader)
+	}
+
+	var (
+		members []*Member
+
+		// GNU ar's long name table, once we've seen it.
+		names []byte
+	)
+
+	off := int64(len(magic))
+	for {
+		if _, err := io.ReadFull(br, buf); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading header at %d: %w", off, err)
+		}
+
+		m, err := parseHeader(buf, off)
+		if err != nil {
+			return nil, err
+		}
+
+		// Read the long name, if any, then skip the rest.
+		if err := m.longName(br); err != nil {
+			return nil, err
+		}
+
+		end := m.end()
+		data := m.Size
+		if m.Name == "//" {
+			if names, err = m.nameTable(br); err != nil {
+				return nil, err
+			}
+			data = 0
+		} else if err := m.gnuName(names); err != nil {
+			return nil, err
+		}
+
+		pad := end - m.Offset - m.Size
+		if n, err := br.Discard(int(data + pad)); err != nil {
+			// The padding byte after the last member is sometimes missing.
+			if !(err == io.EOF && int64(n) >= data) {
+				return nil, fmt.Errorf("skipping %s: %w", m.Name, err)
+			}
+		}
+
+		members = append(members, m)
+		off = end
+	}
+
+	return members, nil
+}
+
+// end returns the offset just past m's data, padded to an even offset.
+func (m *Member) end() int64 {
+	end := m.Offset + m.Size
+	return end + end%2
+}
+
+func parseHeader(buf []byte, off int64) (*Member, error) {
+	if string(buf[58:60]) != "`\n" {
+		return nil, fmt.Errorf("%w: bad magic at %d", ErrHeader, off)
+	}
+
+	field := func(from, to int) string {
+		return strings.TrimRight(string(buf[from:to]), " ")
+	}
+
+	m := &Member{
+		HeaderOffset: off,
+		Offset:       off + headerSize,
+	}
+
+	// GNU ar terminates names with a slash, except for its symbol table ("/") and long name table ("//").
+	m.Name = field(0, 16)
+	if m.Name != "/" && m.Name != "//" {
+		m.Name = strings.TrimSuffix(m.Name, "/")
+	}
+
+	var err error
+	num := func(s string, base int) int64 {
+		if s == "" || err != nil {
+			return 0
+		}
+		var n int64
+		n, err = strconv.ParseInt(s, base, 64)
+		return n
+	}
+
+	m.ModTime = time.Unix(num(field(16, 28), 10), 0)
+	m.Uid = int(num(field(28, 34), 10))
+	m.Gid = int(num(field(34, 40), 10))
+	m.Mode = num(field(40, 48), 8)
+	m.Size = num(field(48, 58), 10)
+	if err != nil || m.Size < 0 {
+		return nil, fmt.Errorf("%w: at %d: %q", ErrHeader, off, bytes.TrimRight(buf, "\n"))
+	}
+
+	return m, nil
+}
+
+// longName handles BSD-style "#1/<len>" names, which are stored at the start of the data.
+// r is positioned at the start of m's data.
+func (m *Member) longName(r io.Reader) error {
+	s, ok := strings.CutPrefix(m.Name, "#1/")
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > m.Size || n > 4096 {
+		return fmt.Errorf("%w: bad BSD name %q", ErrHeader, m.Name)
+	}
+
+	name := make([]byte, n)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return fmt.Errorf("reading name for %s: %w", m.Name, err)
+	}
+
+	m.Name = string(bytes.TrimRight(name, "\x00"))
+	m.Offset += n
+	m.Size -= n
+
+	return nil
+}
+
+// nameTable reads GNU ar's long name table, which is the data of the "//" member m.
+// r is positioned at the start of m's data.
+func (m *Member) nameTable(r io.Reader) ([]byte, error) {
+	if m.Size > maxNameTable {
+		return nil, fmt.Errorf("%w: long name table is too big: %d bytes", ErrHeader, m.Size)
+	}
+
+	names := make([]byte, m.Size)
+	if _, err := io.ReadFull(r, names); err != nil {
+		return nil, fmt.Errorf("reading long name table: %w", err)
+	}
+
+	return names, nil
+}
+
+// gnuName handles GNU-style "/<offset>" names, which point into the long name table (names)
+// where each name ends with "/\n".
+func (m *Member) gnuName(names []byte) error {
+	s, ok := strings.CutPrefix(m.Name, "/")
+	if !ok {
+		return nil
+	}
+
+	// Anything else starting with a slash ("/", "/SYM64/") is a symbol table, not a long name.
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	if n < 0 || n >= int64(len(names)) {
+		return fmt.Errorf("%w: GNU name %q is outside the long name table", ErrHeader, m.Name)
+	}
+
+	name, _, _ := bytes.Cut(names[n:], []byte("\n"))
+	m.Name = strings.TrimSuffix(string(name), "/")
+
+	return nil
+}
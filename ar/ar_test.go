@@ -0,0 +1,156 @@
+package ar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type file struct {
+	name, content string
+}
+
+// write writes an ar archive with GNU names, or BSD names for anything with a space.
+func write(files ...file) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(magic)
+	for _, f := range files {
+		name, content := f.name+"/", f.content
+		if bytes.ContainsRune([]byte(f.name), ' ') {
+			name, content = fmt.Sprintf("#1/%d", len(f.name)), f.name+f.content
+		}
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 1700000000, 0, 0, 0o644, len(content))
+		buf.WriteString(content)
+		if len(content)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	files := []file{
+		{"debian-binary", "2.0\n"},
+		{"odd", "abc"},
+		{"long name.txt", "bsd"},
+		{"empty", ""},
+	}
+	b := write(files...)
+
+	r, err := NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := Index(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Members) != len(files) || len(indexed) != len(files) {
+		t.Fatalf("got %d and %d members, want %d", len(r.Members), len(indexed), len(files))
+	}
+
+	for i, f := range files {
+		m := r.Members[i]
+		if m.Name != f.name {
+			t.Errorf("Members[%d].Name: got %q, want %q", i, m.Name, f.name)
+		}
+		if *indexed[i] != *m {
+			t.Errorf("Index()[%d]: got %+v, want %+v", i, indexed[i], m)
+		}
+		if m.Mode != 0o644 || m.ModTime.Unix() != 1700000000 {
+			t.Errorf("Members[%d]: got mode %o, mtime %v", i, m.Mode, m.ModTime)
+		}
+
+		sr, err := r.Open(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != f.content {
+			t.Errorf("Open(%q): got %q, want %q", f.name, got, f.content)
+		}
+	}
+
+	if _, err := r.Open("missing"); err == nil {
+		t.Errorf("Open(missing): want error")
+	}
+
+	// The final padding byte is optional.
+	trimmed := write(file{"odd", "abc"})
+	trimmed = trimmed[:len(trimmed)-1]
+	if _, err := Index(bytes.NewReader(trimmed)); err != nil {
+		t.Errorf("Index without final padding: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(trimmed), int64(len(trimmed))); err != nil {
+		t.Errorf("NewReader without final padding: %v", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("!<arch>\nnope")), 12); !errors.Is(err, io.EOF) {
+		t.Errorf("short header: got %v", err)
+	}
+	if _, err := Index(bytes.NewReader([]byte("not an ar archive"))); !errors.Is(err, ErrHeader) {
+		t.Errorf("bad magic: got %v", err)
+	}
+}
+
+func TestGNULongNames(t *testing.T) {
+	long := "a-very-long-member-name.txt"
+	table := long + "/\n"
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(magic)
+	for _, f := range []file{{"//", table}, {"/0", "gnu"}, {"short/", "ok"}} {
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", f.name, 1700000000, 0, 0, 0o644, len(f.content))
+		buf.WriteString(f.content)
+		if len(f.content)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	b := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := Index(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"//", long, "short"}
+	if len(r.Members) != len(want) || len(indexed) != len(want) {
+		t.Fatalf("got %d and %d members, want %d", len(r.Members), len(indexed), len(want))
+	}
+	for i, name := range want {
+		if got := r.Members[i].Name; got != name {
+			t.Errorf("Members[%d].Name: got %q, want %q", i, got, name)
+		}
+		if *indexed[i] != *r.Members[i] {
+			t.Errorf("Index()[%d]: got %+v, want %+v", i, indexed[i], r.Members[i])
+		}
+	}
+
+	sr, err := r.Open(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(sr); err != nil || string(got) != "gnu" {
+		t.Errorf("Open(%q): got %q, %v", long, got, err)
+	}
+
+	// A long name without a table to look it up in is an error.
+	orphan := write(file{"x", "y"})
+	copy(orphan[len(magic):], "/0              ")
+	if _, err := NewReader(bytes.NewReader(orphan), int64(len(orphan))); !errors.Is(err, ErrHeader) {
+		t.Errorf("NewReader(no table): got %v", err)
+	}
+	if _, err := Index(bytes.NewReader(orphan)); !errors.Is(err, ErrHeader) {
+		t.Errorf("Index(no table): got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package bsip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+)
+
+const (
+	// Every block starts with the BCD of pi.
+	blockMagic = 0x314159265359
+
+	// The end of a stream is marked by the BCD of sqrt(pi).
+	eosMagic = 0x177245385090
+
+	magicBits = 48
+	mask48    = 1<<magicBits - 1
+)
+
+// bitsAt returns n (at most 57) bits of b starting at bit off, most significant bit first.
+func bitsAt(b []byte, off int64, n uint) uint64 {
+	var v uint64
+	i, shift := off/8, uint(off%8)
+	have := uint(0)
+	for have < n+shift {
+		v = v<<8 | uint64(b[i])
+		i++
+		have += 8
+	}
+	return v >> (have - shift - n) & (1<<n - 1)
+}
+
+// bitWriter packs bits most significant bit first, the way bzip2 does.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.bits += n
+	for w.bits >= 8 {
+		w.bits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.bits))
+	}
+}
+
+// copy writes the bits of b in [from, to).
+func (w *bitWriter) copy(b []byte, from, to int64) {
+	for ; from+8 <= to; from += 8 {
+		w.write(bitsAt(b, from, 8), 8)
+	}
+	if from < to {
+		n := uint(to - from)
+		w.write(bitsAt(b, from, n), n)
+	}
+}
+
+// flush pads the last byte with zeros.
+func (w *bitWriter) flush() []byte {
+	if w.bits != 0 {
+		w.write(0, 8-w.bits)
+	}
+	return w.buf
+}
+
+// decodeBlock decompresses the block in b that starts at bit off and is n bits long.
+//
+// compress/bzip2 can't start in the middle of a stream, so we wrap the block in a stream of its own:
+// a header with the original block size, the block shifted to start on a byte boundary, and an
+// end of stream marker. For a single block, the stream CRC is just the block CRC.
+func decodeBlock(b []byte, off, n int64, level int) ([]byte, error) {
+	if n < magicBits+32 {
+		return nil, fmt.Errorf("block at bit %d is too short (%d bits)", off, n)
+	}
+
+	crc := bitsAt(b, off+magicBits, 32)
+
+	w := &bitWriter{buf: make([]byte, 0, n/8+16)}
+	w.buf = append(w.buf, 'B', 'Z', 'h', byte('0'+level))
+	w.copy(b, off, off+n)
+	w.write(eosMagic, magicBits)
+	w.write(crc, 32)
+
+	return io.ReadAll(bzip2.NewReader(bytes.NewReader(w.flush())))
+}
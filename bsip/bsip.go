@@ -0,0 +1,246 @@
+// Package bsip is gsip for bzip2: it implements io.ReaderAt over a bzip2 stream.
+//
+// bzip2 is much easier to seek around in than gzip. Each block (up to 900k of input) is compressed
+// independently, so there's no window to checkpoint, just where each block starts. The catch is that
+// blocks aren't byte aligned, so we find them by looking for their 48-bit magic number at every bit offset.
+package bsip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Block is where a bzip2 block lives in the compressed and decompressed streams.
+type Block struct {
+	// In is the bit offset of the block's magic number.
+	In int64
+
+	// Bits is the length of the compressed block.
+	Bits int64
+
+	// Out is the offset of the block's first decompressed byte.
+	Out int64
+
+	// Size is how many bytes the block decompresses to.
+	Size int64
+
+	// Level is the block size (1-9) from the header of the stream this block is in.
+	Level int
+}
+
+// Index contains the metadata used by [Reader] to skip around a bzip2 stream.
+// The layout will absolutely change and break you if you depend on it.
+type Index struct {
+	Blocks []Block
+
+	// Done is set once we've found the end of the last stream.
+	Done bool `json:",omitempty"`
+}
+
+type Reader struct {
+	ra   io.ReaderAt
+	size int64
+
+	mu     sync.Mutex
+	blocks []Block
+	done   bool
+
+	// The most recently decoded block.
+	cached int
+	data   []byte
+
+	// Serializes extending the index.
+	fmu sync.Mutex
+	f   *frontier
+}
+
+func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
+	hdr := make([]byte, 4)
+	if _, err := ra.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	level, ok := header(hdr)
+	if !ok {
+		return nil, fmt.Errorf("not a bzip2 stream: %q", hdr)
+	}
+
+	f := &frontier{
+		s:     newScanner(io.NewSectionReader(ra, 0, size), 0),
+		level: level,
+		min:   int64(len(hdr)) * 8,
+	}
+
+	return &Reader{
+		ra:     ra,
+		size:   size,
+		blocks: []Block{},
+		cached: -1,
+		f:      f,
+	}, nil
+}
+
+// Decode restores a Reader from an index written by [Reader.Encode].
+// If the index is incomplete, we pick up scanning where it left off.
+func Decode(ra io.ReaderAt, size int64, index io.Reader) (*Reader, error) {
+	idx := Index{}
+	if err := json.NewDecoder(index).Decode(&idx); err != nil {
+		return nil, err
+	}
+
+	if len(idx.Blocks) == 0 && !idx.Done {
+		return NewReader(ra, size)
+	}
+
+	r := &Reader{
+		ra:     ra,
+		size:   size,
+		blocks: idx.Blocks,
+		done:   idx.Done,
+		cached: -1,
+	}
+
+	if !r.done {
+		last := idx.Blocks[len(idx.Blocks)-1]
+		end := last.In + last.Bits
+		from := end / 8
+
+		r.f = &frontier{
+			s:     newScanner(io.NewSectionReader(ra, from, size-from), from),
+			level: last.Level,
+			out:   last.Out + last.Size,
+			min:   end,
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Reader) Encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Index())
+}
+
+// Index returns a snapshot of the blocks discovered so far.
+func (r *Reader) Index() *Index {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Index{
+		Blocks: slices.Clone(r.blocks),
+		Done:   r.done,
+	}
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("ReadAt: negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		b, data, err := r.block(off + int64(n))
+		if err == io.EOF {
+			return n, io.EOF
+		}
+		if err != nil {
+			return n, fmt.Errorf("ReadAt %d: %w", off, err)
+		}
+
+		n += copy(p[n:], data[off+int64(n)-b.Out:])
+	}
+
+	return n, nil
+}
+
+// find returns the index of the block containing off, if we know about it.
+// Must be called with mu held.
+func (r *Reader) find(off int64) (int, bool) {
+	i := sort.Search(len(r.blocks), func(i int) bool {
+		return r.blocks[i].Out+r.blocks[i].Size > off
+	})
+	return i, i < len(r.blocks)
+}
+
+// block returns the block containing off and its contents.
+func (r *Reader) block(off int64) (Block, []byte, error) {
+	r.mu.Lock()
+	i, ok := r.find(off)
+	if !ok && !r.done {
+		r.mu.Unlock()
+		if err := r.extend(off); err != nil {
+			return Block{}, nil, err
+		}
+		r.mu.Lock()
+		i, ok = r.find(off)
+	}
+	if !ok {
+		r.mu.Unlock()
+		return Block{}, nil, io.EOF
+	}
+
+	b := r.blocks[i]
+	if r.cached == i {
+		data := r.data
+		r.mu.Unlock()
+		return b, data, nil
+	}
+	r.mu.Unlock()
+
+	from := b.In / 8
+	buf := make([]byte, (b.In+b.Bits+7)/8-from)
+	if _, err := r.ra.ReadAt(buf, from); err != nil && !(err == io.EOF && from+int64(len(buf)) == r.size) {
+		return Block{}, nil, fmt.Errorf("reading block at bit %d: %w", b.In, err)
+	}
+
+	data, err := decodeBlock(buf, b.In-from*8, b.Bits, b.Level)
+	if err != nil {
+		return Block{}, nil, fmt.Errorf("decoding block at bit %d: %w", b.In, err)
+	}
+	if int64(len(data)) != b.Size {
+		return Block{}, nil, fmt.Errorf("block at bit %d decoded to %d bytes, index says %d", b.In, len(data), b.Size)
+	}
+
+	r.mu.Lock()
+	r.cached, r.data = i, data
+	r.mu.Unlock()
+
+	return b, data, nil
+}
+
+// extend scans forward until we find the block containing off or run out of blocks.
+func (r *Reader) extend(off int64) error {
+	r.fmu.Lock()
+	defer r.fmu.Unlock()
+
+	for {
+		r.mu.Lock()
+		_, ok := r.find(off)
+		done := r.done
+		r.mu.Unlock()
+
+		if ok || done {
+			return nil
+		}
+
+		b, data, err := r.f.advance()
+		if errors.Is(err, io.EOF) {
+			r.mu.Lock()
+			r.done = true
+			r.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.blocks = append(r.blocks, b)
+		r.cached, r.data = len(r.blocks)-1, data
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,191 @@
+package bsip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"testing"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// checkReadAt compares random reads from r against want.
+func checkReadAt(t *testing.T, r io.ReaderAt, want []byte) {
+	t.Helper()
+
+	// Decoding a block is expensive, so don't go overboard.
+	size := int64(len(want))
+	for range 20 {
+		start := rand.Int64N(size)
+		end := rand.Int64N(size-start) + start
+
+		got := make([]byte, end-start)
+		n, err := r.ReadAt(got, start)
+		if err != nil {
+			t.Fatalf("ReadAt(%d, %d): %v", start, len(got), err)
+		}
+		if !bytes.Equal(got[:n], want[start:end]) {
+			t.Fatalf("ReadAt(%d, %d): wrong data", start, len(got))
+		}
+	}
+
+	// A read that runs off the end gets what's there plus io.EOF.
+	p := make([]byte, 100)
+	n, err := r.ReadAt(p, size-10)
+	if n != 10 || err != io.EOF {
+		t.Errorf("ReadAt past the end: got %d, %v; want 10, io.EOF", n, err)
+	}
+}
+
+func TestBsip(t *testing.T) {
+	want := readFile(t, "../gsip/testdata/Mark.Twain-Tom.Sawyer.txt")
+	zb := readFile(t, "testdata/Mark.Twain-Tom.Sawyer.txt.bz2")
+
+	r, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkReadAt(t, r, want)
+
+	// This was compressed with -1, so there should be a block per 100k.
+	idx := r.Index()
+	if !idx.Done {
+		t.Errorf("index isn't done")
+	}
+	if got, want := len(idx.Blocks), (len(want)+99999)/100000; got != want {
+		t.Errorf("got %d blocks, want %d", got, want)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	want := readFile(t, "../gsip/testdata/Mark.Twain-Tom.Sawyer.txt")
+	zb := readFile(t, "testdata/Mark.Twain-Tom.Sawyer.txt.bz2")
+
+	r, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only index part of the stream so Decode has to pick up where we left off.
+	if _, err := r.ReadAt(make([]byte, 10), 150000); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := r.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(zb), int64(len(zb)), buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.blocks) != 2 || decoded.done {
+		t.Fatalf("decoded %d blocks, done=%t; want 2, false", len(decoded.blocks), decoded.done)
+	}
+
+	checkReadAt(t, decoded, want)
+}
+
+func TestConcatenated(t *testing.T) {
+	want := readFile(t, "../gsip/testdata/Mark.Twain-Tom.Sawyer.txt")
+	zb := readFile(t, "testdata/Mark.Twain-Tom.Sawyer.txt.bz2")
+
+	// Like pbzip2, or cat a.bz2 b.bz2.
+	want = append(want, want...)
+	zb = append(zb, zb...)
+
+	// Make sure we agree with compress/bzip2.
+	got, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("compress/bzip2 disagrees about concatenated streams")
+	}
+
+	r, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkReadAt(t, r, want)
+}
+
+func TestTruncated(t *testing.T) {
+	zb := readFile(t, "testdata/Mark.Twain-Tom.Sawyer.txt.bz2")
+	zb = zb[:len(zb)/2]
+
+	r, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadAll(io.NewSectionReader(r, 0, 1<<20)); err == nil {
+		t.Fatal("want error for truncated stream")
+	}
+}
+
+func TestNotBzip2(t *testing.T) {
+	zb := readFile(t, "../gsip/testdata/Mark.Twain-Tom.Sawyer.txt.gz")
+	if _, err := NewReader(bytes.NewReader(zb), int64(len(zb))); err == nil {
+		t.Fatal("want error for gzip")
+	}
+}
+
+func TestTarfs(t *testing.T) {
+	zb := readFile(t, "testdata/gsip.tar.bz2")
+
+	r, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := tarfs.New(r, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open("../tarfs/testdata/gsip.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	plain, err := tarfs.New(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for e := range plain.Entries() {
+		if !e.Header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		want, err := fs.ReadFile(plain, e.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := fs.ReadFile(fsys, e.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %d bytes, want %d", e.Filename, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package bsip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressed blocks are a little bigger than 900k in the worst case.
+// If we've gone this far without finding the end of a block, something is wrong.
+const maxBlockBits = 8 << 21
+
+type candidate struct {
+	pos int64 // bit offset of the magic
+	eos bool
+}
+
+// scanner looks for block and end of stream magic numbers at every bit offset.
+//
+// Those can show up by chance inside compressed data, so each one is only a candidate
+// until decoding the block that ends there succeeds.
+type scanner struct {
+	r *bufio.Reader
+
+	// Bytes we've read starting at base, kept around until the block they're in is decoded.
+	buf  []byte
+	base int64
+
+	reg   uint64 // the last 64 bits we've seen
+	seen  int64  // how many bits are in reg
+	queue []candidate
+}
+
+func newScanner(r io.Reader, base int64) *scanner {
+	return &scanner{
+		r:    bufio.NewReaderSize(r, 1<<20),
+		base: base,
+	}
+}
+
+// end is the offset of the next byte we'll read.
+func (s *scanner) end() int64 {
+	return s.base + int64(len(s.buf))
+}
+
+// scan reads one more byte, queueing any magic numbers that end in it.
+func (s *scanner) scan() error {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	pos := s.end() * 8
+	s.buf = append(s.buf, b)
+
+	for i := 7; i >= 0; i-- {
+		s.reg = s.reg<<1 | uint64(b>>i&1)
+		s.seen++
+		pos++
+
+		if s.seen < magicBits {
+			continue
+		}
+		switch s.reg & mask48 {
+		case blockMagic:
+			s.queue = append(s.queue, candidate{pos: pos - magicBits})
+		case eosMagic:
+			s.queue = append(s.queue, candidate{pos: pos - magicBits, eos: true})
+		}
+	}
+
+	return nil
+}
+
+// next returns the next candidate at or after bit min.
+func (s *scanner) next(min int64) (candidate, error) {
+	for {
+		for len(s.queue) != 0 {
+			c := s.queue[0]
+			s.queue = s.queue[1:]
+			if c.pos >= min {
+				return c, nil
+			}
+		}
+
+		if err := s.scan(); err != nil {
+			return candidate{}, err
+		}
+	}
+}
+
+// bytes returns the bytes in [from, to), reading more if needed.
+// The result is short if we hit the end.
+func (s *scanner) bytes(from, to int64) []byte {
+	for s.end() < to {
+		if err := s.scan(); err != nil {
+			break
+		}
+	}
+	return s.buf[from-s.base : min(to, s.end())-s.base]
+}
+
+// drop forgets the bytes before off.
+func (s *scanner) drop(off int64) {
+	if off > s.base {
+		s.buf = s.buf[off-s.base:]
+		s.base = off
+	}
+}
+
+// frontier finds and decodes blocks in order, extending the index as it goes.
+type frontier struct {
+	s *scanner
+
+	level int   // block size of the current stream
+	out   int64 // decompressed offset of the next block
+	min   int64 // ignore candidates before this bit
+
+	// Where the next block (or the end of the stream) starts, once we know.
+	pending *candidate
+}
+
+// header parses a stream header, returning its block size level.
+func header(b []byte) (int, bool) {
+	if len(b) != 4 || b[0] != 'B' || b[1] != 'Z' || b[2] != 'h' || b[3] < '1' || b[3] > '9' {
+		return 0, false
+	}
+	return int(b[3] - '0'), true
+}
+
+// advance returns the next block and its contents, or io.EOF after the last stream.
+func (f *frontier) advance() (Block, []byte, error) {
+	for {
+		c, err := f.candidate()
+		if err != nil {
+			return Block{}, nil, err
+		}
+
+		if !c.eos {
+			return f.block(c)
+		}
+
+		// The end of stream marker is followed by a CRC and padding to a byte boundary.
+		end := (c.pos + magicBits + 32 + 7) / 8
+
+		// Another stream might follow, e.g. from pbzip2 or cat.
+		level, ok := header(f.s.bytes(end, end+4))
+		if !ok {
+			return Block{}, nil, io.EOF
+		}
+
+		f.level = level
+		f.min = (end + 4) * 8
+		f.s.drop(end)
+	}
+}
+
+func (f *frontier) candidate() (candidate, error) {
+	if c := f.pending; c != nil {
+		f.pending = nil
+		return *c, nil
+	}
+
+	c, err := f.s.next(f.min)
+	if err == io.EOF {
+		return c, fmt.Errorf("looking for the next block at bit %d: %w", f.min, io.ErrUnexpectedEOF)
+	}
+	return c, err
+}
+
+// block decodes the block at c, trying each later candidate as its end until one works.
+func (f *frontier) block(c candidate) (Block, []byte, error) {
+	var derr error
+	for {
+		end, err := f.s.next(c.pos + 1)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Block{}, nil, errors.Join(fmt.Errorf("finding the end of the block at bit %d: %w", c.pos, err), derr)
+		}
+
+		n := end.pos - c.pos
+		if n > maxBlockBits {
+			return Block{}, nil, errors.Join(fmt.Errorf("block at bit %d: no end within %d bits", c.pos, maxBlockBits), derr)
+		}
+
+		from := c.pos / 8
+		data, err := decodeBlock(f.s.bytes(from, (end.pos+7)/8), c.pos-from*8, n, f.level)
+		if err != nil {
+			// Probably a magic number that showed up by chance, so keep going.
+			derr = err
+			continue
+		}
+
+		b := Block{
+			In:    c.pos,
+			Bits:  n,
+			Out:   f.out,
+			Size:  int64(len(data)),
+			Level: f.level,
+		}
+
+		f.out += b.Size
+		f.min = end.pos
+		f.pending = &end
+		f.s.drop(end.pos / 8)
+
+		return b, data, nil
+	}
+}
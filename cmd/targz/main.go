@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz"
+	"github.com/jonjohnsonjr/targz/registry"
+)
+
+var platform = flag.String("platform", runtime.GOOS+"/"+runtime.GOARCH, "which image to pick from a multi-platform docker:// reference, as os/arch[/variant]")
+
+func main() {
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: targz [--platform os/arch] <path, url or docker://ref> [addr]")
+	}
+
+	if ref, ok := strings.CutPrefix(args[0], "docker://"); ok {
+		img, err := registry.New(registry.WithPlatform(*platform)).Image(context.TODO(), ref)
+		if err != nil {
+			return err
+		}
+
+		fsys := img.FS()
+		if len(args) == 1 {
+			return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+				fmt.Println(p)
+				return err
+			})
+		}
+
+		return http.ListenAndServe(args[1], http.FileServer(http.FS(fsys)))
+	}
+
+	fsys, closer, err := targz.Open(context.TODO(), args[0])
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	if len(args) == 1 {
+		return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if p == "." {
+				return nil
+			}
+
+			e, err := fsys.Entry(p)
+			if err != nil {
+				return fmt.Errorf("Entry(%q): %w", p, err)
+			}
+			fmt.Printf("%d+%d: %s\n", e.Offset, e.Size(), p)
+			return nil
+		})
+	}
+
+	return http.ListenAndServe(args[1], http.FileServer(http.FS(fsys)))
+}
@@ -0,0 +1,535 @@
+// Package cpiofs indexes cpio archives (newc and odc, as used by initramfs images and RPM payloads)
+// so their files can be read in place, the same way tarfs does for tar.
+//
+// Like tarfs, [New] reads every header once to record where each file's data lives, and [FS.Encode]
+// saves that index so it can be restored later by [Decode] without reading the archive again.
+// Over a [gsip.Reader], that gives random access into a gzipped cpio.
+//
+// [gsip.Reader]: https://pkg.go.dev/github.com/jonjohnsonjr/targz/gsip#Reader
+package cpiofs
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+type Entry struct {
+	Header Header
+	Offset int64
+
+	// HeaderOffset is where this entry's header starts.
+	HeaderOffset int64 `json:",omitempty"`
+
+	// Hardlink is set for entries that share their data with another entry.
+	//
+	// cpio identifies hardlinks by inode number, and the newc writers only store the data once
+	// (with the last link), so the other links borrow Offset and Header.Size from the entry named here.
+	Hardlink string `json:",omitempty"`
+
+	Filename string
+	dir      string
+	fi       fs.FileInfo
+
+	// Which of several concatenated archives this came from, since they can reuse inode numbers.
+	archive int
+}
+
+func (e Entry) Name() string {
+	return e.fi.Name()
+}
+
+func (e Entry) Size() int64 {
+	return e.Header.Size
+}
+
+func (e Entry) Type() fs.FileMode {
+	return e.fi.Mode().Type()
+}
+
+func (e Entry) Info() (fs.FileInfo, error) {
+	return e.fi, nil
+}
+
+func (e Entry) IsDir() bool {
+	return e.fi.IsDir()
+}
+
+type File struct {
+	Entry *Entry
+
+	fsys *FS
+	sr   *io.SectionReader
+
+	// current position in readdir listing
+	cursor int
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.Entry.fi, nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	return f.sr.Read(p)
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.sr.ReadAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.sr.Seek(offset, whence)
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	dir, err := f.fsys.readDir(f.Entry.Filename)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: f.Entry.Filename, Err: err}
+	}
+
+	if f.cursor >= len(dir) {
+		if n < 0 {
+			return nil, nil
+		}
+
+		return nil, io.EOF
+	}
+
+	if n > 0 && len(dir)-f.cursor > n {
+		ret := dir[f.cursor : f.cursor+n]
+		f.cursor += n
+		return ret, nil
+	}
+
+	ret := dir[f.cursor:]
+	f.cursor = len(dir)
+
+	return ret, nil
+}
+
+type FS struct {
+	ra    io.ReaderAt
+	files []*Entry
+	index map[string]int
+	dirs  map[string][]fs.DirEntry
+
+	// Contains real or synthesized entry for "."
+	root *Entry
+
+	// The end of the archive, including the trailer and any padding after it.
+	size int64
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadLinkFS = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := fsys.lstat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+
+	return e.Info()
+}
+
+// lstat resolves every component of name except the last one.
+func (fsys *FS) lstat(name string) (*Entry, error) {
+	resolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return e, nil
+}
+
+func (fsys *FS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := fsys.lstat(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	if e.Header.Mode&modeType != modeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.New("not a symlink")}
+	}
+
+	return e.Header.Linkname, nil
+}
+
+// lookup returns the entry for an already-resolved name.
+// Directories that only exist implicitly (as a parent of some entry) get a synthesized entry.
+func (fsys *FS) lookup(name string) (*Entry, bool) {
+	if name == "." {
+		return fsys.root, true
+	}
+
+	if i, ok := fsys.index[name]; ok {
+		return fsys.files[i], true
+	}
+
+	if _, ok := fsys.dirs[name]; ok {
+		return &Entry{
+			Header: Header{
+				Name: name,
+				Mode: modeDir | 0o755,
+			},
+			Filename: name,
+			dir:      path.Dir(name),
+			fi:       tarfs.ImplicitDir(path.Base(name)),
+		}, true
+	}
+
+	return nil, false
+}
+
+// lookupLink is lookup as a [tarfs.LookupFunc].
+func (fsys *FS) lookupLink(name string) (fs.FileInfo, string, bool) {
+	e, ok := fsys.lookup(name)
+	if !ok {
+		return nil, "", false
+	}
+
+	if e.Header.Mode&modeType != modeSymlink {
+		return e.fi, "", true
+	}
+
+	return e.fi, e.Header.Linkname, true
+}
+
+// resolve walks name one component at a time, treating the root of the archive as a chroot,
+// just like tarfs does. If follow is false, a symlink in the final component is returned as-is.
+func (fsys *FS) resolve(name string, follow bool) (string, error) {
+	return tarfs.Resolve(name, follow, fsys.lookupLink)
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &File{
+		Entry: e,
+		fsys:  fsys,
+		sr:    fsys.data(e),
+	}, nil
+}
+
+// data returns the contents of a regular file, or nothing for anything else.
+func (fsys *FS) data(e *Entry) *io.SectionReader {
+	if e.Header.Mode&modeType != modeRegular {
+		return io.NewSectionReader(bytes.NewReader(nil), 0, 0)
+	}
+
+	return io.NewSectionReader(fsys.ra, e.Offset, e.Header.Size)
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat()
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dirs, err := fsys.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return dirs, nil
+}
+
+func (fsys *FS) readDir(name string) ([]fs.DirEntry, error) {
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, ok := fsys.dirs[resolved]
+	if !ok {
+		return []fs.DirEntry{}, nil
+	}
+
+	return dirs, nil
+}
+
+func newFS(ra io.ReaderAt) *FS {
+	return &FS{
+		ra:    ra,
+		files: []*Entry{},
+		index: map[string]int{},
+		dirs:  map[string][]fs.DirEntry{},
+		root: &Entry{
+			dir:      ".",
+			Filename: ".",
+			Header: Header{
+				Name: ".",
+				Mode: modeDir,
+			},
+			fi: tarfs.Root{},
+		},
+	}
+}
+
+// add appends an entry to the index, filling in the fields we don't serialize.
+func (fsys *FS) add(entry *Entry) {
+	dir := path.Dir(entry.Filename)
+
+	// If the archive contains a "." entry, we don't want ReadDir() to return itself.
+	if entry.Filename == "." && dir == "." {
+		dir = ""
+	}
+
+	entry.dir = dir
+	entry.fi = entry.Header.FileInfo()
+
+	fsys.index[entry.Filename] = len(fsys.files)
+	fsys.files = append(fsys.files, entry)
+
+	// If this is the root entry, stash it for later.
+	if dir == "" {
+		fsys.root = entry
+	}
+}
+
+// finish pre-generates the results of ReadDir so we don't allocate a ton if fs.WalkDir calls us.
+func (fsys *FS) finish() {
+	for i, f := range fsys.files {
+		// Skip entries that were overwritten by a later entry with the same name.
+		if fsys.index[f.Filename] != i {
+			continue
+		}
+		fsys.dirs[f.dir] = append(fsys.dirs[f.dir], f)
+	}
+
+	// Archives made with find | cpio often omit parent directories, so synthesize them.
+	implicit := map[string]struct{}{}
+	for _, f := range fsys.files {
+		for dir := f.dir; dir != "." && dir != ""; dir = path.Dir(dir) {
+			if _, ok := fsys.index[dir]; ok {
+				break
+			}
+			if _, ok := implicit[dir]; ok {
+				break
+			}
+			implicit[dir] = struct{}{}
+
+			e, _ := fsys.lookup(dir)
+			fsys.dirs[e.dir] = append(fsys.dirs[e.dir], e)
+		}
+	}
+
+	for _, files := range fsys.dirs {
+		slices.SortFunc(files, func(a, b fs.DirEntry) int {
+			return cmp.Compare(a.Name(), b.Name())
+		})
+	}
+}
+
+// New indexes the cpio archive in ra, which is size bytes long.
+// A negative size means the caller doesn't know it, e.g. for a [gsip.Reader].
+func New(ra io.ReaderAt, size int64) (*FS, error) {
+	if size < 0 {
+		size = 1<<63 - 1
+	}
+
+	s := newScanner(io.NewSectionReader(ra, 0, size))
+
+	entries, err := scan(s)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newFS(ra)
+	for _, e := range entries {
+		fsys.add(e)
+	}
+	fsys.size = s.off
+	fsys.finish()
+
+	return fsys, nil
+}
+
+// scan reads every entry and links up hardlinks.
+func scan(s *scanner) ([]*Entry, error) {
+	var entries []*Entry
+	for {
+		e, err := s.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e.Filename = normalize(e.Header.Name)
+		entries = append(entries, e)
+	}
+
+	link(entries)
+
+	return entries, nil
+}
+
+type inode struct {
+	archive  int
+	dev, ino uint64
+}
+
+// link points regular files that have no data of their own at the link that does (in the same archive).
+func link(entries []*Entry) {
+	owners := map[inode]*Entry{}
+	for _, e := range entries {
+		if e.Header.Nlink > 1 && e.Header.Mode&modeType == modeRegular && e.Header.Size > 0 {
+			owners[inode{e.archive, e.Header.Dev, e.Header.Ino}] = e
+		}
+	}
+
+	for _, e := range entries {
+		if e.Header.Nlink <= 1 || e.Header.Mode&modeType != modeRegular || e.Header.Size != 0 {
+			continue
+		}
+
+		owner, ok := owners[inode{e.archive, e.Header.Dev, e.Header.Ino}]
+		if !ok {
+			continue
+		}
+
+		e.Hardlink = owner.Filename
+		e.Offset = owner.Offset
+		e.Header.Size = owner.Header.Size
+	}
+}
+
+// Entry returns the entry for name without following any symlinks.
+func (fsys *FS) Entry(name string) (*Entry, error) {
+	i, ok := fsys.index[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return fsys.files[i], nil
+}
+
+// Entries yields every entry in archive order, skipping any that were overwritten by a later entry with the same name.
+func (fsys *FS) Entries() iter.Seq[*Entry] {
+	return func(yield func(*Entry) bool) {
+		for i, e := range fsys.files {
+			if fsys.index[e.Filename] != i {
+				continue
+			}
+
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+func (fsys *FS) Encode(w io.Writer) error {
+	toc := TOC{
+		Entries: fsys.files,
+		Size:    fsys.size,
+	}
+
+	return json.NewEncoder(w).Encode(&toc)
+}
+
+// Decode restores an FS from a TOC written by [FS.Encode].
+func Decode(ra io.ReaderAt, r io.Reader) (*FS, error) {
+	toc := TOC{}
+	if err := json.NewDecoder(r).Decode(&toc); err != nil {
+		return nil, err
+	}
+
+	fsys := newFS(ra)
+	for _, e := range toc.Entries {
+		fsys.add(e)
+	}
+	fsys.size = toc.Size
+	fsys.finish()
+
+	return fsys, nil
+}
+
+type TOC struct {
+	Entries []*Entry
+
+	// Size of the whole archive, including the trailer.
+	Size int64 `json:",omitempty"`
+}
+
+func normalize(s string) string {
+	// Trim prefix of "/" and prefix of "./"
+	// Trim suffix of "/"
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(s, "/"), "/"), "./")
+	if s == "" {
+		return "."
+	}
+	return s
+}
+
+// Index returns a list of cpio entries with their offsets.
+//
+// Like tarfs.Index, this is useful if you don't have an io.ReaderAt but still want to know the offsets.
+func Index(r io.Reader) ([]*Entry, error) {
+	entries, err := scan(newScanner(r))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		e.dir = path.Dir(e.Filename)
+		e.fi = e.Header.FileInfo()
+	}
+
+	return entries, nil
+}
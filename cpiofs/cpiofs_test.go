@@ -0,0 +1,244 @@
+package cpiofs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+)
+
+type cpioFile struct {
+	name  string
+	mode  uint32
+	ino   uint64
+	nlink int
+	data  string
+}
+
+// makeCpio writes files as a cpio archive in the given format.
+func makeCpio(format Format, files ...cpioFile) []byte {
+	buf := &bytes.Buffer{}
+	pad := func(n int) {
+		if format != FormatODC {
+			for buf.Len()%n != 0 {
+				buf.WriteByte(0)
+			}
+		}
+	}
+
+	write := func(f cpioFile) {
+		nlink := max(f.nlink, 1)
+		if format == FormatODC {
+			fmt.Fprintf(buf, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o", 1, f.ino, f.mode, 0, 0, nlink, 0, 1700000000, len(f.name)+1, len(f.data))
+		} else {
+			fmt.Fprintf(buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x", f.ino, f.mode, 0, 0, nlink, 1700000000, len(f.data), 0, 1, 0, 0, len(f.name)+1, 0)
+		}
+		buf.WriteString(f.name)
+		buf.WriteByte(0)
+		pad(4)
+		buf.WriteString(f.data)
+		pad(4)
+	}
+
+	for _, f := range files {
+		write(f)
+	}
+	write(cpioFile{name: trailer})
+
+	// cpio pads to a 512 byte block.
+	for buf.Len()%512 != 0 {
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
+
+func files() []cpioFile {
+	return []cpioFile{
+		{name: ".", mode: modeDir | 0o755, ino: 1},
+		{name: "etc", mode: modeDir | 0o755, ino: 2},
+		{name: "etc/hostname", mode: modeRegular | 0o644, ino: 3, data: "cpio\n"},
+		{name: "usr/bin/busybox", mode: modeRegular | 0o755, ino: 4, data: strings.Repeat("busybox", 1000)},
+		{name: "bin", mode: modeSymlink | 0o777, ino: 5, data: "usr/bin"},
+
+		// newc only stores the data with the last link.
+		{name: "usr/bin/sh", mode: modeRegular | 0o755, ino: 6, nlink: 2},
+		{name: "usr/bin/ash", mode: modeRegular | 0o755, ino: 6, nlink: 2, data: "#!/bin/busybox\n"},
+	}
+}
+
+func TestFS(t *testing.T) {
+	for _, format := range []Format{FormatNewc, FormatODC} {
+		t.Run(format.String(), func(t *testing.T) {
+			b := makeCpio(format, files()...)
+
+			fsys, err := New(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fsys.size != int64(len(b)) {
+				t.Errorf("size: got %d, want %d", fsys.size, len(b))
+			}
+
+			if err := fstest.TestFS(fsys, "etc/hostname", "usr/bin/busybox", "usr/bin/sh", "usr/bin/ash"); err != nil {
+				t.Fatal(err)
+			}
+
+			check(t, fsys)
+		})
+	}
+}
+
+func check(t *testing.T, fsys *FS) {
+	t.Helper()
+
+	for name, want := range map[string]string{
+		"etc/hostname": "cpio\n",
+		"usr/bin/sh":   "#!/bin/busybox\n",
+		"bin/ash":      "#!/bin/busybox\n",
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if link, err := fsys.ReadLink("bin"); err != nil || link != "usr/bin" {
+		t.Errorf("ReadLink(bin): got %q, %v", link, err)
+	}
+
+	fi, err := fsys.Lstat("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("Lstat(bin): got mode %v", fi.Mode())
+	}
+
+	e, err := fsys.Entry("usr/bin/sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Header.Format == FormatNewc && e.Hardlink != "usr/bin/ash" {
+		t.Errorf("usr/bin/sh: got Hardlink %q, want usr/bin/ash", e.Hardlink)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	zbuf := &bytes.Buffer{}
+	zw := gzip.NewWriter(zbuf)
+	zw.Write(makeCpio(FormatNewc, files()...))
+	zw.Close()
+
+	zr, err := gsip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(zr, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check(t, fsys)
+}
+
+func TestConcatenated(t *testing.T) {
+	b := makeCpio(FormatNewc, cpioFile{name: "kernel/x86/microcode/GenuineIntel.bin", mode: modeRegular | 0o644, ino: 1, data: "ucode"})
+	b = append(b, makeCpio(FormatNewc, files()...)...)
+
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Stat(fsys, "kernel/x86/microcode/GenuineIntel.bin"); err != nil {
+		t.Error(err)
+	}
+	check(t, fsys)
+}
+
+func TestConcatenatedInodes(t *testing.T) {
+	// Both archives use inode 6, but an empty link in the second has nothing to do with the first.
+	b := makeCpio(FormatNewc, cpioFile{name: "old", mode: modeRegular | 0o644, ino: 6, nlink: 2, data: "stale"})
+	b = append(b, makeCpio(FormatNewc, cpioFile{name: "empty", mode: modeRegular | 0o644, ino: 6, nlink: 2})...)
+
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := fs.ReadFile(fsys, "empty"); err != nil || len(got) != 0 {
+		t.Errorf("ReadFile(empty): got %q, %v", got, err)
+	}
+	e, err := fsys.Entry("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Hardlink != "" {
+		t.Errorf("Entry(empty): got Hardlink %q", e.Hardlink)
+	}
+
+	entries, err := Index(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Filename == "empty" && e.Hardlink != "" {
+			t.Errorf("Index: empty got Hardlink %q", e.Hardlink)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	b := makeCpio(FormatNewc, files()...)
+
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toc := &bytes.Buffer{}
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(b), toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.size != fsys.size {
+		t.Errorf("size: got %d, want %d", decoded.size, fsys.size)
+	}
+	check(t, decoded)
+
+	entries, err := Index(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files()) {
+		t.Fatalf("Index: got %d entries, want %d", len(entries), len(files()))
+	}
+	for i, e := range entries {
+		if want := fsys.files[i]; e.Offset != want.Offset || e.HeaderOffset != want.HeaderOffset {
+			t.Errorf("Index[%d]: got %d/%d, want %d/%d", i, e.HeaderOffset, e.Offset, want.HeaderOffset, want.Offset)
+		}
+	}
+}
+
+func TestBadMagic(t *testing.T) {
+	b := []byte("070799" + strings.Repeat("0", 200))
+	if _, err := New(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Fatal("want error for bad magic")
+	}
+}
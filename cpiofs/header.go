@@ -0,0 +1,367 @@
+package cpiofs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Format is the flavor of cpio header.
+type Format int
+
+const (
+	// FormatNewc is the "new ASCII" format (070701) used by initramfs and RPM.
+	FormatNewc Format = iota + 1
+
+	// FormatCRC is newc with a checksum of the data (070702).
+	FormatCRC
+
+	// FormatODC is the old POSIX.1 portable format (070707).
+	FormatODC
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatNewc:
+		return "newc"
+	case FormatCRC:
+		return "crc"
+	case FormatODC:
+		return "odc"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+const (
+	newcSize = 110
+	odcSize  = 76
+	trailer  = "TRAILER!!!"
+)
+
+// Names and symlink targets longer than this are probably garbage.
+const maxNameSize = 64 << 10
+
+// ErrHeader is returned for anything that doesn't look like a cpio header.
+var ErrHeader = errors.New("cpiofs: invalid header")
+
+// File types from the high bits of st_mode.
+const (
+	modeType    = 0o170000
+	modeSocket  = 0o140000
+	modeSymlink = 0o120000
+	modeRegular = 0o100000
+	modeBlock   = 0o060000
+	modeDir     = 0o040000
+	modeChar    = 0o020000
+	modeFIFO    = 0o010000
+)
+
+// Header is a cpio header.
+type Header struct {
+	Format Format
+
+	Name string
+	Mode uint32 // st_mode, including the file type
+	Uid  int
+	Gid  int
+
+	Nlink   int
+	ModTime time.Time
+	Size    int64
+
+	// Dev and Ino identify hardlinks.
+	Dev uint64
+	Ino uint64
+
+	// Rdev is the device for block and character devices.
+	Rdev uint64
+
+	// Check is the checksum of the data for FormatCRC.
+	Check uint32 `json:",omitempty"`
+
+	// Linkname is the target of a symlink, which cpio stores as the symlink's data.
+	Linkname string `json:",omitempty"`
+}
+
+// FileMode converts Mode to an fs.FileMode.
+func (h *Header) FileMode() fs.FileMode {
+	mode := fs.FileMode(h.Mode & 0o777)
+	if h.Mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if h.Mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if h.Mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	switch h.Mode & modeType {
+	case modeDir:
+		mode |= fs.ModeDir
+	case modeSymlink:
+		mode |= fs.ModeSymlink
+	case modeSocket:
+		mode |= fs.ModeSocket
+	case modeBlock:
+		mode |= fs.ModeDevice
+	case modeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case modeFIFO:
+		mode |= fs.ModeNamedPipe
+	}
+
+	return mode
+}
+
+// FileInfo returns an fs.FileInfo for the header.
+func (h *Header) FileInfo() fs.FileInfo {
+	return headerFileInfo{h}
+}
+
+type headerFileInfo struct {
+	h *Header
+}
+
+func (fi headerFileInfo) Name() string       { return path.Base(fi.h.Name) }
+func (fi headerFileInfo) Size() int64        { return fi.h.Size }
+func (fi headerFileInfo) Mode() fs.FileMode  { return fi.h.FileMode() }
+func (fi headerFileInfo) ModTime() time.Time { return fi.h.ModTime }
+func (fi headerFileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi headerFileInfo) Sys() any           { return fi.h }
+
+// scanner reads headers one at a time, keeping track of offsets.
+type scanner struct {
+	r   *bufio.Reader
+	off int64
+	buf [newcSize]byte
+
+	// How many trailers we've seen, i.e. which of several concatenated archives we're in.
+	archive int
+}
+
+func newScanner(r io.Reader) *scanner {
+	return &scanner{r: bufio.NewReaderSize(r, 1<<20)}
+}
+
+func (s *scanner) read(p []byte) error {
+	n, err := io.ReadFull(s.r, p)
+	s.off += int64(n)
+	return err
+}
+
+func (s *scanner) discard(n int64) error {
+	for n > 0 {
+		d, err := s.r.Discard(int(min(n, 1<<30)))
+		s.off += int64(d)
+		n -= int64(d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// align skips padding up to a multiple of n.
+func (s *scanner) align(n int64) error {
+	if rem := s.off % n; rem != 0 {
+		return s.discard(n - rem)
+	}
+	return nil
+}
+
+// Next returns the next entry, or io.EOF after the last trailer.
+//
+// Some archives (like initramfs images) are several cpio archives concatenated together,
+// possibly with zeros in between, so after a trailer we keep going if there's another header.
+func (s *scanner) Next() (*Entry, error) {
+	for {
+		e, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Header.Name != trailer {
+			return e, nil
+		}
+
+		s.archive++
+		if err := s.skipZeros(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipZeros skips padding after a trailer, returning io.EOF unless another cpio archive follows.
+func (s *scanner) skipZeros() error {
+	for {
+		b, err := s.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != 0 {
+			break
+		}
+		if err := s.discard(1); err != nil {
+			return err
+		}
+	}
+
+	// Anything else (like a compressed archive appended to an initramfs) is none of our business.
+	if b, err := s.r.Peek(4); err != nil || string(b) != "0707" {
+		return io.EOF
+	}
+
+	return nil
+}
+
+func (s *scanner) next() (*Entry, error) {
+	e := &Entry{HeaderOffset: s.off, archive: s.archive}
+
+	magic := s.buf[:6]
+	if err := s.read(magic); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: truncated magic at %d", ErrHeader, e.HeaderOffset)
+		}
+		return nil, err
+	}
+
+	var (
+		hdr      = &e.Header
+		namesize int64
+		err      error
+	)
+
+	switch string(magic) {
+	case "070701", "070702":
+		hdr.Format = FormatNewc
+		if string(magic) == "070702" {
+			hdr.Format = FormatCRC
+		}
+		namesize, err = s.newc(hdr)
+	case "070707":
+		hdr.Format = FormatODC
+		namesize, err = s.odc(hdr)
+	default:
+		return nil, fmt.Errorf("%w: bad magic %q at %d", ErrHeader, magic, e.HeaderOffset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w at %d: %w", ErrHeader, e.HeaderOffset, err)
+	}
+
+	if namesize <= 0 || namesize > maxNameSize {
+		return nil, fmt.Errorf("%w: name size %d at %d", ErrHeader, namesize, e.HeaderOffset)
+	}
+
+	name := make([]byte, namesize)
+	if err := s.read(name); err != nil {
+		return nil, noEOF(err)
+	}
+	hdr.Name = string(name[:len(name)-1])
+
+	align := int64(1)
+	if hdr.Format != FormatODC {
+		align = 4
+	}
+
+	if err := s.align(align); err != nil {
+		return nil, noEOF(err)
+	}
+	e.Offset = s.off
+
+	if hdr.Mode&modeType == modeSymlink && hdr.Size <= maxNameSize {
+		target := make([]byte, hdr.Size)
+		if err := s.read(target); err != nil {
+			return nil, noEOF(err)
+		}
+		hdr.Linkname = string(target)
+	} else if err := s.discard(hdr.Size); err != nil {
+		return nil, noEOF(err)
+	}
+
+	if err := s.align(align); err != nil && !(err == io.EOF && hdr.Name == trailer) {
+		return nil, noEOF(err)
+	}
+
+	return e, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// newc parses the rest of a newc header (after the magic), returning the name size.
+func (s *scanner) newc(hdr *Header) (int64, error) {
+	buf := s.buf[:newcSize-6]
+	if err := s.read(buf); err != nil {
+		return 0, noEOF(err)
+	}
+
+	var err error
+	field := func(i int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		n, err = strconv.ParseUint(string(buf[i*8:(i+1)*8]), 16, 32)
+		return n
+	}
+
+	hdr.Ino = field(0)
+	hdr.Mode = uint32(field(1))
+	hdr.Uid = int(field(2))
+	hdr.Gid = int(field(3))
+	hdr.Nlink = int(field(4))
+	hdr.ModTime = time.Unix(int64(field(5)), 0)
+	hdr.Size = int64(field(6))
+	hdr.Dev = field(7)<<32 | field(8)
+	hdr.Rdev = field(9)<<32 | field(10)
+	namesize := int64(field(11))
+	hdr.Check = uint32(field(12))
+
+	return namesize, err
+}
+
+// odc parses the rest of an odc header (after the magic), returning the name size.
+func (s *scanner) odc(hdr *Header) (int64, error) {
+	buf := s.buf[:odcSize-6]
+	if err := s.read(buf); err != nil {
+		return 0, noEOF(err)
+	}
+
+	var (
+		err error
+		pos int
+	)
+	field := func(width int) uint64 {
+		f := buf[pos : pos+width]
+		pos += width
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		n, err = strconv.ParseUint(string(f), 8, 64)
+		return n
+	}
+
+	hdr.Dev = field(6)
+	hdr.Ino = field(6)
+	hdr.Mode = uint32(field(6))
+	hdr.Uid = int(field(6))
+	hdr.Gid = int(field(6))
+	hdr.Nlink = int(field(6))
+	hdr.Rdev = field(6)
+	hdr.ModTime = time.Unix(int64(field(11)), 0)
+	namesize := int64(field(6))
+	hdr.Size = int64(field(11))
+
+	return namesize, err
+}
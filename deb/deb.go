@@ -0,0 +1,105 @@
+// Package deb reads Debian packages in place.
+//
+// A .deb is an ar archive with a debian-binary version, a control.tar.* and a data.tar.*.
+// [Open] only reads the ar headers up front, then indexes each tar (through a [gsip.Reader] if it's gzipped),
+// so over something like ranger you can list and read files without downloading the whole package.
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz/ar"
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Package is an opened .deb.
+type Package struct {
+	// Version is the contents of debian-binary, e.g. "2.0".
+	Version string
+
+	Control *tarfs.FS
+	Data    *tarfs.FS
+
+	// Archive has the offsets of every ar member.
+	Archive *ar.Reader
+}
+
+// UnsupportedCompressionError is returned for members compressed with something other than gzip, like xz or zstd.
+type UnsupportedCompressionError struct {
+	Name string
+}
+
+func (e *UnsupportedCompressionError) Error() string {
+	return fmt.Sprintf("%s: only uncompressed and gzipped tars are supported", e.Name)
+}
+
+// Open reads the .deb in ra, which is size bytes long. opts are passed to [tarfs.New] for each tar.
+func Open(ra io.ReaderAt, size int64, opts ...tarfs.Option) (*Package, error) {
+	archive, err := ar.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Package{Archive: archive}
+
+	sr, err := archive.Open("debian-binary")
+	if err != nil {
+		return nil, err
+	}
+	version, err := io.ReadAll(io.LimitReader(sr, 64))
+	if err != nil {
+		return nil, fmt.Errorf("reading debian-binary: %w", err)
+	}
+	p.Version = string(bytes.TrimSpace(version))
+
+	if !strings.HasPrefix(p.Version, "2.") {
+		return nil, fmt.Errorf("unsupported debian-binary version %q", p.Version)
+	}
+
+	for _, m := range archive.Members {
+		switch {
+		case strings.HasPrefix(m.Name, "control.tar"):
+			p.Control, err = openTar(archive, m, opts)
+		case strings.HasPrefix(m.Name, "data.tar"):
+			p.Data, err = openTar(archive, m, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", m.Name, err)
+		}
+	}
+
+	if p.Control == nil || p.Data == nil {
+		return nil, fmt.Errorf("missing control.tar or data.tar")
+	}
+
+	return p, nil
+}
+
+// openTar indexes a (possibly gzipped) tar member.
+func openTar(archive *ar.Reader, m *ar.Member, opts []tarfs.Option) (*tarfs.FS, error) {
+	var (
+		ra   io.ReaderAt = archive.Section(m)
+		size             = m.Size
+	)
+
+	switch path.Ext(m.Name) {
+	case ".tar":
+	case ".gz":
+		zr, err := gsip.NewReader(ra, size)
+		if err != nil {
+			return nil, err
+		}
+
+		// We don't know the uncompressed size.
+		ra, size = zr, -1
+	default:
+		return nil, &UnsupportedCompressionError{Name: m.Name}
+	}
+
+	return tarfs.New(ra, size, opts...)
+}
@@ -0,0 +1,112 @@
+package deb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func makeTar(t *testing.T, files ...string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := 0; i < len(files); i += 2 {
+		name, content := files[i], files[i+1]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gz(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// makeDeb writes members (name, content pairs) to an ar archive.
+func makeDeb(members ...string) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("!<arch>\n")
+	for i := 0; i < len(members); i += 2 {
+		name, content := members[i], members[i+1]
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0o644, len(content))
+		buf.WriteString(content)
+		if len(content)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	control := makeTar(t, "./control", "Package: hello\nVersion: 1.0\n", "./md5sums", "")
+	data := makeTar(t, "./usr/bin/hello", "#!/bin/sh\necho hello\n", "./usr/share/doc/hello/copyright", "MIT")
+
+	for _, tc := range []struct {
+		name          string
+		control, data string
+	}{
+		{"gzip", "control.tar.gz", "data.tar.gz"},
+		{"uncompressed", "control.tar", "data.tar"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, d := control, data
+			if tc.name == "gzip" {
+				c, d = gz(c), gz(d)
+			}
+			b := makeDeb("debian-binary", "2.0\n", tc.control, string(c), tc.data, string(d))
+
+			p, err := Open(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Version != "2.0" {
+				t.Errorf("Version: got %q", p.Version)
+			}
+
+			got, err := fs.ReadFile(p.Control, "control")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(got, []byte("Package: hello")) {
+				t.Errorf("control: got %q", got)
+			}
+
+			got, err = fs.ReadFile(p.Data, "usr/bin/hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "#!/bin/sh\necho hello\n" {
+				t.Errorf("usr/bin/hello: got %q", got)
+			}
+
+			if len(p.Archive.Members) != 3 {
+				t.Errorf("Members: got %d, want 3", len(p.Archive.Members))
+			}
+		})
+	}
+
+	t.Run("xz", func(t *testing.T) {
+		b := makeDeb("debian-binary", "2.0\n", "control.tar.gz", string(gz(control)), "data.tar.xz", "not really xz")
+
+		_, err := Open(bytes.NewReader(b), int64(len(b)))
+		var uerr *UnsupportedCompressionError
+		if !errors.As(err, &uerr) || uerr.Name != "data.tar.xz" {
+			t.Errorf("Open: want UnsupportedCompressionError for data.tar.xz, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+// Package diff compares two [tarfs.FS] values, e.g. the same layer from two releases of an image.
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Kind is whether a path was added, removed or modified.
+type Kind int
+
+const (
+	Added Kind = iota + 1
+	Removed
+	Modified
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Change is a set of flags describing what changed about a modified path.
+type Change uint
+
+const (
+	// Type is set when the file type changed, e.g. a file became a symlink.
+	// Nothing else is compared in that case.
+	Type Change = 1 << iota
+
+	// Content is set when the contents of a regular file changed.
+	Content
+
+	// Mode is set when the permission bits (including setuid, setgid and sticky) changed.
+	Mode
+
+	// Owner is set when the uid, gid, uname or gname changed.
+	Owner
+
+	// Xattrs is set when the extended attributes changed.
+	Xattrs
+
+	// Link is set when the target of a symlink or hardlink changed.
+	Link
+
+	// Device is set when the major or minor number of a device changed.
+	Device
+
+	// ModTime is set when the modification time changed.
+	ModTime
+)
+
+var changeNames = []string{"type", "content", "mode", "owner", "xattrs", "link", "device", "mtime"}
+
+func (c Change) String() string {
+	var names []string
+	for i, name := range changeNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Delta describes a single path that differs between two filesystems.
+type Delta struct {
+	Path string
+	Kind Kind
+
+	// Changes is only set for Modified paths.
+	Changes Change
+
+	// Old is nil for Added paths and New is nil for Removed paths.
+	Old, New *tarfs.Entry
+}
+
+func (d Delta) String() string {
+	if d.Kind == Modified {
+		return fmt.Sprintf("%s %s (%s)", d.Kind, d.Path, d.Changes)
+	}
+	return fmt.Sprintf("%s %s", d.Kind, d.Path)
+}
+
+// Diff yields every path that differs between a and b, sorted by path, so parents come before their children.
+//
+// Contents are compared by size first, then by digest if both entries have one (see [tarfs.WithDigests]),
+// and only read from both filesystems as a last resort.
+func Diff(a, b *tarfs.FS) iter.Seq2[Delta, error] {
+	return func(yield func(Delta, error) bool) {
+		before, after := entries(a), entries(b)
+
+		names := slices.Sorted(maps.Keys(before))
+		for name := range after {
+			if _, ok := before[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			ea, eb := before[name], after[name]
+
+			d := Delta{
+				Path: name,
+				Old:  ea,
+				New:  eb,
+			}
+
+			switch {
+			case ea == nil:
+				d.Kind = Added
+			case eb == nil:
+				d.Kind = Removed
+			default:
+				changes, err := compare(a, b, ea, eb)
+				if err != nil {
+					yield(d, err)
+					return
+				}
+				if changes == 0 {
+					continue
+				}
+
+				d.Kind = Modified
+				d.Changes = changes
+			}
+
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+}
+
+func entries(fsys *tarfs.FS) map[string]*tarfs.Entry {
+	m := map[string]*tarfs.Entry{}
+	for e := range fsys.Entries() {
+		m[e.Filename] = e
+	}
+	return m
+}
+
+func compare(a, b *tarfs.FS, ea, eb *tarfs.Entry) (Change, error) {
+	ma, mb := ea.Header.FileInfo().Mode(), eb.Header.FileInfo().Mode()
+	if ma.Type() != mb.Type() || (ea.Header.Typeflag == tar.TypeLink) != (eb.Header.Typeflag == tar.TypeLink) {
+		return Type, nil
+	}
+
+	var c Change
+	if ma.Perm()|ma&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != mb.Perm()|mb&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) {
+		c |= Mode
+	}
+	if ea.Uid() != eb.Uid() || ea.Gid() != eb.Gid() || ea.Uname() != eb.Uname() || ea.Gname() != eb.Gname() {
+		c |= Owner
+	}
+	if !maps.Equal(ea.Xattrs(), eb.Xattrs()) {
+		c |= Xattrs
+	}
+	if ea.Header.Linkname != eb.Header.Linkname {
+		c |= Link
+	}
+	if ea.Devmajor() != eb.Devmajor() || ea.Devminor() != eb.Devminor() {
+		c |= Device
+	}
+	if !ea.Header.ModTime.Equal(eb.Header.ModTime) {
+		c |= ModTime
+	}
+
+	if ma.IsRegular() && ea.Header.Typeflag != tar.TypeLink {
+		same, err := sameContent(a, b, ea, eb)
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			c |= Content
+		}
+	}
+
+	return c, nil
+}
+
+func sameContent(a, b *tarfs.FS, ea, eb *tarfs.Entry) (bool, error) {
+	if ea.Header.Size != eb.Header.Size {
+		return false, nil
+	}
+
+	// The same entry of the same archive.
+	if a == b && ea.Offset == eb.Offset {
+		return true, nil
+	}
+
+	if ea.Digest() != "" && eb.Digest() != "" {
+		return ea.Digest() == eb.Digest(), nil
+	}
+
+	fa, err := a.Open(ea.Filename)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := b.Open(eb.Filename)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	return equalReaders(fa, fb)
+}
+
+func equalReaders(a, b io.Reader) (bool, error) {
+	bufa, bufb := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(a, bufa)
+		nb, errb := io.ReadFull(b, bufb)
+
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+
+		eofa := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		eofb := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		if erra != nil && !eofa {
+			return false, erra
+		}
+		if errb != nil && !eofb {
+			return false, errb
+		}
+		if eofa || eofb {
+			return eofa == eofb, nil
+		}
+	}
+}
+
+const whiteoutPrefix = ".wh."
+
+// WriteLayer writes an OCI layer that turns a into b when applied on top of it.
+//
+// Removed paths become whiteout files, collapsed to just the topmost removed directory.
+// Added and modified entries are copied from b, see [tarfs.FS.WriteTarFunc].
+func WriteLayer(w io.Writer, a, b *tarfs.FS) error {
+	var (
+		whiteouts []string
+		changed   = map[string]struct{}{}
+
+		// Paths whose children don't need whiteouts of their own because the path was removed or replaced.
+		replaced []string
+	)
+	covered := func(name string) bool {
+		for _, dir := range replaced {
+			if strings.HasPrefix(name, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for d, err := range Diff(a, b) {
+		if err != nil {
+			return err
+		}
+
+		switch d.Kind {
+		case Removed:
+			if !covered(d.Path) {
+				whiteouts = append(whiteouts, d.Path)
+				replaced = append(replaced, d.Path)
+			}
+		case Added:
+			changed[d.Path] = struct{}{}
+		case Modified:
+			changed[d.Path] = struct{}{}
+
+			// A directory replaced by something else takes all of its children with it.
+			if d.Changes&Type != 0 && !d.New.IsDir() {
+				replaced = append(replaced, d.Path)
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for _, name := range whiteouts {
+		dir, base := path.Split(name)
+		hdr := &tar.Header{
+			Name:     dir + whiteoutPrefix + base,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	// Don't Close, since b writes the rest of the archive, including the trailer.
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	return b.WriteTarFunc(w, func(e *tarfs.Entry) bool {
+		_, ok := changed[e.Filename]
+		return ok
+	})
+}
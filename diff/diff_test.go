@@ -0,0 +1,197 @@
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+type file struct {
+	hdr     tar.Header
+	content string
+}
+
+func build(t *testing.T, files []file, opts ...tarfs.Option) *tarfs.FS {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := f.hdr
+		hdr.Size = int64(len(f.content))
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	fsys, err := tarfs.New(bytes.NewReader(b), int64(len(b)), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys
+}
+
+func TestDiff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	before := []file{
+		{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "etc/same", Typeflag: tar.TypeReg}, content: "same"},
+		{hdr: tar.Header{Name: "etc/content", Typeflag: tar.TypeReg}, content: "before"},
+		{hdr: tar.Header{Name: "etc/mode", Typeflag: tar.TypeReg}, content: "x"},
+		{hdr: tar.Header{Name: "etc/owner", Typeflag: tar.TypeReg}, content: "x"},
+		{hdr: tar.Header{Name: "etc/xattr", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.a": "1"}}, content: "x"},
+		{hdr: tar.Header{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "same"}},
+		{hdr: tar.Header{Name: "etc/mtime", Typeflag: tar.TypeReg, ModTime: now}},
+		{hdr: tar.Header{Name: "etc/type", Typeflag: tar.TypeReg}, content: "x"},
+		{hdr: tar.Header{Name: "gone/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "gone/a", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "gone/b", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "removed", Typeflag: tar.TypeReg}},
+	}
+	after := []file{
+		{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "etc/same", Typeflag: tar.TypeReg}, content: "same"},
+		{hdr: tar.Header{Name: "etc/content", Typeflag: tar.TypeReg}, content: "after!"},
+		{hdr: tar.Header{Name: "etc/mode", Typeflag: tar.TypeReg, Mode: 0o4755}, content: "x"},
+		{hdr: tar.Header{Name: "etc/owner", Typeflag: tar.TypeReg, Uid: 1000}, content: "x"},
+		{hdr: tar.Header{Name: "etc/xattr", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.a": "2"}}, content: "x"},
+		{hdr: tar.Header{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "content"}},
+		{hdr: tar.Header{Name: "etc/mtime", Typeflag: tar.TypeReg, ModTime: now.Add(time.Hour)}},
+		{hdr: tar.Header{Name: "etc/type", Typeflag: tar.TypeSymlink, Linkname: "same"}},
+		{hdr: tar.Header{Name: "new", Typeflag: tar.TypeReg}, content: "new"},
+	}
+
+	want := []string{
+		"modified etc/content (content)",
+		"modified etc/link (link)",
+		"modified etc/mode (mode)",
+		"modified etc/mtime (mtime)",
+		"modified etc/owner (owner)",
+		"modified etc/type (type)",
+		"modified etc/xattr (xattrs)",
+		"removed gone",
+		"removed gone/a",
+		"removed gone/b",
+		"added new",
+		"removed removed",
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []tarfs.Option
+	}{
+		{name: "read"},
+		{name: "digests", opts: []tarfs.Option{tarfs.WithDigests()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := build(t, before, tc.opts...), build(t, after, tc.opts...)
+
+			var got []string
+			for d, err := range Diff(a, b) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, d.String())
+			}
+
+			if !slices.Equal(got, want) {
+				t.Errorf("Diff:\nwant %q\n got %q", want, got)
+			}
+
+			// A layer that removes gone (but not each of its children) and removed, and includes everything else.
+			layer := &bytes.Buffer{}
+			if err := WriteLayer(layer, a, b); err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			tr := tar.NewReader(layer)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, hdr.Name)
+			}
+
+			wantNames := []string{
+				".wh.gone",
+				".wh.removed",
+				"etc/content",
+				"etc/mode",
+				"etc/owner",
+				"etc/xattr",
+				"etc/link",
+				"etc/mtime",
+				"etc/type",
+				"new",
+			}
+			if !slices.Equal(names, wantNames) {
+				t.Errorf("WriteLayer:\nwant %q\n got %q", wantNames, names)
+			}
+		})
+	}
+
+	// Nothing differs from itself.
+	a := build(t, before)
+	for d, err := range Diff(a, a) {
+		t.Errorf("Diff(a, a): got %v, %v", d, err)
+	}
+}
+
+func TestDiffSub(t *testing.T) {
+	fsys := build(t, []file{
+		{hdr: tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "a/same", Typeflag: tar.TypeReg}, content: "same"},
+		{hdr: tar.Header{Name: "a/content", Typeflag: tar.TypeReg}, content: "before"},
+		{hdr: tar.Header{Name: "a/removed", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "b/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "b/same", Typeflag: tar.TypeReg}, content: "same"},
+		{hdr: tar.Header{Name: "b/content", Typeflag: tar.TypeReg}, content: "after!"},
+		{hdr: tar.Header{Name: "b/added", Typeflag: tar.TypeReg}},
+	})
+
+	sub := func(dir string) *tarfs.FS {
+		s, err := fsys.Sub(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.(*tarfs.FS)
+	}
+
+	// Comparing contents has to open each file through its own view.
+	var got []string
+	for d, err := range Diff(sub("a"), sub("b")) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d.String())
+	}
+
+	want := []string{
+		"added added",
+		"modified content (content)",
+		"removed removed",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Diff:\nwant %q\n got %q", want, got)
+	}
+}
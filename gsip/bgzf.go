@@ -0,0 +1,106 @@
+package gsip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/flate"
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+)
+
+// BGZFHeaderSize is how much of a stream [IsBGZF] needs to see.
+const BGZFHeaderSize = 18
+
+// IsBGZF reports whether hdr is the start of a BGZF block, a gzip member whose extra field
+// has a "BC" subfield with the size of the block.
+//
+// BGZF (from samtools) is a series of gzip members that each decompress to at most 64k,
+// so every member boundary is a checkpoint that doesn't need a window.
+func IsBGZF(hdr []byte) bool {
+	_, _, ok := bgzfBlock(hdr)
+	return ok
+}
+
+// bgzfBlock parses the start of a BGZF block, returning the length of its header and of the whole block.
+func bgzfBlock(hdr []byte) (int64, int64, bool) {
+	if len(hdr) < BGZFHeaderSize || hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3] != 4 {
+		return 0, 0, false
+	}
+
+	// samtools always writes the BC subfield first and nothing else.
+	xlen := int64(binary.LittleEndian.Uint16(hdr[10:12]))
+	if xlen != 6 || hdr[12] != 'B' || hdr[13] != 'C' || binary.LittleEndian.Uint16(hdr[14:16]) != 2 {
+		return 0, 0, false
+	}
+
+	bsize := int64(binary.LittleEndian.Uint16(hdr[16:18])) + 1
+	if bsize < BGZFHeaderSize+8 {
+		return 0, 0, false
+	}
+
+	return 12 + xlen, bsize, true
+}
+
+// NewBGZFReader builds a complete index for a BGZF stream by walking its block headers and trailers,
+// without decompressing anything.
+//
+// With [WithIndexStore], the index comes from the store if it's there and is put there otherwise,
+// since walking the headers still means reading the whole stream.
+func NewBGZFReader(ra io.ReaderAt, size int64, opts ...Option) (*Reader, error) {
+	r := &Reader{
+		ra:          ra,
+		size:        size,
+		opts:        makeOptions(opts),
+		checkpoints: []*flate.Checkpoint{},
+		readers:     map[*gzip.Reader]bool{},
+	}
+
+	if r.opts.store != nil && r.fromStore() {
+		return r, nil
+	}
+
+	br := bufio.NewReaderSize(io.NewSectionReader(ra, 0, size), 1<<20)
+
+	var (
+		checkpoints = []*flate.Checkpoint{}
+		in, out     int64
+		buf         = make([]byte, BGZFHeaderSize)
+	)
+	for in < size {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("reading BGZF header at %d: %w", in, err)
+		}
+
+		hlen, bsize, ok := bgzfBlock(buf)
+		if !ok {
+			return nil, fmt.Errorf("%w: not a BGZF block at %d", gzip.ErrHeader, in)
+		}
+
+		checkpoints = append(checkpoints, &flate.Checkpoint{
+			In:     in + hlen,
+			Out:    out,
+			Empty:  true,
+			Member: in,
+		})
+
+		// The trailer ends with ISIZE.
+		if _, err := br.Discard(int(bsize - BGZFHeaderSize - 4)); err != nil {
+			return nil, fmt.Errorf("skipping BGZF block at %d: %w", in, err)
+		}
+		if _, err := io.ReadFull(br, buf[:4]); err != nil {
+			return nil, fmt.Errorf("reading BGZF trailer at %d: %w", in, err)
+		}
+
+		in += bsize
+		out += int64(binary.LittleEndian.Uint32(buf[:4]))
+	}
+
+	r.checkpoints = checkpoints
+	if r.opts.store != nil {
+		r.put()
+	}
+
+	return r, nil
+}
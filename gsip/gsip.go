@@ -3,8 +3,10 @@ package gsip
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/jonjohnsonjr/targz/gsip/internal/flate"
@@ -18,40 +20,80 @@ type Index struct {
 }
 
 type Reader struct {
-	ra          io.ReaderAt
-	size        int64
-	updates     chan *flate.Checkpoint
+	ra   io.ReaderAt
+	size int64
+	opts *options
+
+	mu          sync.Mutex
 	checkpoints []*flate.Checkpoint
 
 	// Reader, available.
-	mu      sync.Mutex
 	readers map[*gzip.Reader]bool
+
+	// Set in tolerant mode once we find out the stream is truncated.
+	report *Report
+
+	// The reader created by NewReader, which is the only one that records checkpoints.
+	frontier *gzip.Reader
+
+	// Guards publishing our index to a store.
+	published sync.Once
+
+	// For a Reader from DecodeRanged, where to find the window of each checkpoint,
+	// and (up to maxLoaded of) the windows we've fetched so far.
+	windows  io.ReaderAt
+	windowAt map[*flate.Checkpoint]int64
+	loaded   map[*flate.Checkpoint][]byte
+}
+
+// CompressedSize returns the size of the gzip stream we were created with.
+func (r *Reader) CompressedSize() int64 {
+	return r.size
 }
 
 func (r *Reader) Encode(w io.Writer) error {
-	idx := Index{
-		Checkpoints: r.checkpoints,
+	return json.NewEncoder(w).Encode(r.Index())
+}
+
+// Index returns a snapshot of the checkpoints discovered so far.
+func (r *Reader) Index() *Index {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Index{
+		Checkpoints: slices.Clone(r.checkpoints),
 	}
+}
 
-	return json.NewEncoder(w).Encode(&idx)
+// addCheckpoint is called synchronously by the frontier reader as it discovers checkpoints.
+func (r *Reader) addCheckpoint(checkpoint *flate.Checkpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checkpoints = append(r.checkpoints, checkpoint)
 }
 
-func Decode(ra io.ReaderAt, size int64, index io.Reader) (*Reader, error) {
+func Decode(ra io.ReaderAt, size int64, index io.Reader, opts ...Option) (*Reader, error) {
 	idx := Index{}
 	if err := json.NewDecoder(index).Decode(&idx); err != nil {
 		return nil, err
 	}
 
-	return &Reader{
+	return FromIndex(ra, size, &idx, opts...), nil
+}
+
+func NewReader(ra io.ReaderAt, size int64, opts ...Option) (*Reader, error) {
+	r := &Reader{
 		ra:          ra,
 		size:        size,
-		checkpoints: idx.Checkpoints,
+		opts:        makeOptions(opts),
+		checkpoints: []*flate.Checkpoint{},
 		readers:     map[*gzip.Reader]bool{},
-	}, nil
-}
+	}
 
-func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
-	updates := make(chan *flate.Checkpoint, 10)
+	if r.opts.store != nil && r.fromStore() {
+		return r, nil
+	}
 
 	// This is our first pass frontier reader that sends us updates.
 	// We probably need to do something special to make this work in the face of concurrent ReadAt.
@@ -63,26 +105,13 @@ func NewReader(ra io.ReaderAt, size int64) (*Reader, error) {
 	// Should we implement an optional bufio.ReaderAt?
 	br := bufio.NewReaderSize(sr, 1<<20)
 
-	zr, err := gzip.NewReader(br, updates)
+	zr, err := gzip.NewReader(br, r.addCheckpoint)
 	if err != nil {
 		return nil, fmt.Errorf("gzip.NewReader: %w", err)
 	}
 
-	r := &Reader{
-		ra:          ra,
-		size:        size,
-		updates:     updates,
-		checkpoints: []*flate.Checkpoint{},
-		readers:     map[*gzip.Reader]bool{zr: true},
-	}
-
-	// TODO: Locking around this to make sure it's safe.
-	// TODO: Make sure we don't leak this goroutine.
-	go func() {
-		for checkpoint := range updates {
-			r.checkpoints = append(r.checkpoints, checkpoint)
-		}
-	}()
+	r.readers[zr] = true
+	r.frontier = zr
 
 	return r, nil
 }
@@ -101,8 +130,6 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 		}
 	}
 
-	r.mu.Unlock()
-
 	var highest *flate.Checkpoint
 	for _, checkpoint := range r.checkpoints {
 		if checkpoint.Out > off {
@@ -112,6 +139,8 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 		highest = checkpoint
 	}
 
+	r.mu.Unlock()
+
 	if highest == nil {
 		// No checkpoints probably means we are trying to ReadAt before we index.
 		// Just try to find any reader that isn't already in use (probably the first one).
@@ -130,7 +159,12 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 			r.mu.Unlock()
 
 			discard := off - zr.Offset()
-			if _, err := io.CopyN(io.Discard, zr, discard); err != nil {
+			if err := r.opts.checkDiscard(off, discard); err != nil {
+				r.release(zr)
+				return nil, err
+			}
+			if _, err := io.CopyN(io.Discard, r.limit(zr), discard); err != nil {
+				r.truncated(zr, err)
 				return nil, fmt.Errorf("discarding %d bytes: %w", discard, err)
 			}
 
@@ -141,6 +175,11 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 		return nil, fmt.Errorf("could not find any checkpoints or readers for offset %d", off)
 	}
 
+	discard := off - highest.Out
+	if err := r.opts.checkDiscard(off, discard); err != nil {
+		return nil, err
+	}
+
 	// SectionReader's third arg is length, not absolute end. Passing
 	// r.size let downstream reads ask the underlying ReaderAt for bytes
 	// in [highest.In, highest.In + r.size) — i.e. up to r.size past the
@@ -148,14 +187,19 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 	// reject those requests with 416.
 	sr := io.NewSectionReader(r.ra, highest.In, r.size-highest.In)
 
-	zr, err := gzip.Continue(sr, 0, highest, nil)
+	from, err := r.window(highest)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.Continue(sr, 0, from, nil)
 	if err != nil {
 		return nil, fmt.Errorf("continue: %w", err)
 	}
 
 	// TODO: Make sure this doesn't send a bunch of tiny ReadAts.
-	discard := off - highest.Out
-	if _, err := io.CopyN(io.Discard, zr, discard); err != nil {
+	if _, err := io.CopyN(io.Discard, r.limit(zr), discard); err != nil {
+		r.truncated(zr, err)
 		return nil, fmt.Errorf("discarding %d bytes: %w", discard, err)
 	}
 
@@ -169,31 +213,67 @@ func (r *Reader) acquireReader(off int64) (*gzip.Reader, error) {
 func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
 	zr, err := r.acquireReader(off)
 	if err != nil {
+		if r.opts.tolerant && errors.Is(err, io.ErrUnexpectedEOF) {
+			// Past the end of a truncated stream, which truncated has already recorded.
+			return 0, io.EOF
+		}
 		return 0, fmt.Errorf("acquireReader at %d: %w", off, err)
 	}
 
-	defer func() {
-		r.mu.Lock()
-		defer r.mu.Unlock()
-
-		r.readers[zr] = true
-	}()
+	defer r.release(zr)
 
-	n, err := io.ReadFull(zr, p)
+	n, err := readFull(r.limit(zr), p)
 	if err != nil {
 		// io.ReaderAt contract: a short read at end-of-stream must return
 		// the partial bytes plus io.EOF, not (0, io.ErrUnexpectedEOF). The
 		// latter loses data and breaks callers that wrap the Reader in
 		// io.SectionReader / bufio.Reader (e.g. tarfs.Index).
-		if err == io.ErrUnexpectedEOF || err == io.EOF {
+		if err == io.EOF {
+			r.publish(zr)
+			return n, io.EOF
+		}
+		if r.truncated(zr, err) {
 			return n, io.EOF
 		}
+
+		// This includes io.ErrUnexpectedEOF for a truncated stream, unless we're tolerant.
 		return n, fmt.Errorf("ReadFull at %d: %w", off, err)
 	}
 
 	return n, nil
 }
 
+// readFull is like io.ReadFull, but it doesn't turn io.EOF into io.ErrUnexpectedEOF,
+// so we can tell the clean end of a gzip stream apart from a truncated one.
+func readFull(r io.Reader, p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		nn, err := r.Read(p[n:])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// release returns zr to the pool of available readers.
+func (r *Reader) release(zr *gzip.Reader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.readers[zr] = true
+}
+
+// limit enforces any limits from our options while reading from zr.
+func (r *Reader) limit(zr *gzip.Reader) io.Reader {
+	if r.opts.maxRatio == 0 {
+		return zr
+	}
+
+	return limited{zr: zr, opts: r.opts}
+}
+
 type reader struct {
 	gzip.Reader
 }
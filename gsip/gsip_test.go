@@ -3,11 +3,16 @@ package gsip
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand/v2"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/jonjohnsonjr/targz/store"
 )
 
 func TestGsip(t *testing.T) {
@@ -161,3 +166,445 @@ func TestAcquireReaderRespectsBlobBounds(t *testing.T) {
 		t.Errorf("content mismatch at offset %d", targetOff)
 	}
 }
+
+func TestMerge(t *testing.T) {
+	spans := []Span{
+		{Checkpoint: 2, Compressed: Range{200, 100}, Decompressed: Range{2000, 500}},
+		{Checkpoint: 0, Compressed: Range{0, 100}, Decompressed: Range{0, 900}},
+		{Checkpoint: 1, Compressed: Range{100, 50}, Decompressed: Range{1000, 200}},
+		{Checkpoint: 3, Compressed: Range{250, -1}, Decompressed: Range{3000, 10}},
+	}
+
+	got := Merge(spans)
+	if len(got) != 2 {
+		t.Fatalf("want 2 spans, got %d: %+v", len(got), got)
+	}
+
+	if got[0].Checkpoint != 0 || got[0].Compressed != (Range{0, 150}) || got[0].Decompressed != (Range{0, 1200}) {
+		t.Errorf("first span: got %+v", got[0])
+	}
+
+	if got[1].Checkpoint != 2 || got[1].Compressed != (Range{200, -1}) || got[1].Decompressed != (Range{2000, 1010}) {
+		t.Errorf("second span: got %+v", got[1])
+	}
+}
+
+func TestMergeSameCheckpoint(t *testing.T) {
+	// Both start from the same checkpoint, but the second one wants bytes earlier than the first.
+	spans := []Span{
+		{Checkpoint: 0, Compressed: Range{0, 100}, Decompressed: Range{0, 900}, Discard: 800},
+		{Checkpoint: 0, Compressed: Range{0, 100}, Decompressed: Range{0, 300}, Discard: 200},
+	}
+
+	got := Merge(spans)
+	if len(got) != 1 {
+		t.Fatalf("want 1 span, got %d: %+v", len(got), got)
+	}
+	if got[0].Discard != 200 || got[0].Decompressed != (Range{0, 900}) {
+		t.Errorf("got %+v, want Discard 200 and Decompressed {0 900}", got[0])
+	}
+}
+
+func TestLimits(t *testing.T) {
+	// A gzip bomb: 32MB of zeros compresses to a few KB.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(make([]byte, 32<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bomb := buf.Bytes()
+
+	zr, err := NewReader(bytes.NewReader(bomb), int64(len(bomb)), WithMaxRatio(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = zr.ReadAt(make([]byte, 1), 16<<20)
+	var ratio *RatioLimitError
+	if !errors.As(err, &ratio) {
+		t.Fatalf("ReadAt(bomb): want RatioLimitError, got %v", err)
+	}
+	if ratio.Decompressed < MinRatioCheck || ratio.Limit != 100 {
+		t.Errorf("ReadAt(bomb): unexpected %+v", ratio)
+	}
+
+	// Ordinary text is nowhere near that ratio.
+	text, err := os.ReadFile("./testdata/Mark.Twain-Tom.Sawyer.txt.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err = NewReader(bytes.NewReader(text), int64(len(text)), WithMaxRatio(100), WithMaxDiscard(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := zr.ReadAt(make([]byte, 100), 500); err != nil {
+		t.Fatalf("ReadAt(500): %v", err)
+	}
+
+	_, err = zr.ReadAt(make([]byte, 100), 100000)
+	var discard *DiscardLimitError
+	if !errors.As(err, &discard) {
+		t.Fatalf("ReadAt(100000): want DiscardLimitError, got %v", err)
+	}
+	if discard.Offset != 100000 || discard.Discard <= discard.Limit || discard.Limit != 1000 {
+		t.Errorf("ReadAt(100000): unexpected %+v", discard)
+	}
+
+	// Reading forward in small steps doesn't discard anything.
+	for off := int64(600); off < 100000; off += 100 {
+		if _, err := zr.ReadAt(make([]byte, 100), off); err != nil {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+	}
+}
+
+func TestTolerant(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	data := bytes.Repeat([]byte("hello world, some data "), 100000)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	zr, err := NewReader(bytes.NewReader(truncated), int64(len(truncated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zr.ReadAt(make([]byte, len(data)), 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadAt: want io.ErrUnexpectedEOF, got %v", err)
+	}
+	span, err := zr.Span(0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zr.Fetch(span); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Fetch: want io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	zr, err = NewReader(bytes.NewReader(truncated), int64(len(truncated)), Tolerant())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(data))
+	n, err := zr.ReadAt(got, 0)
+	if err != io.EOF {
+		t.Fatalf("ReadAt: want io.EOF, got %v", err)
+	}
+	if n == 0 || !bytes.Equal(got[:n], data[:n]) {
+		t.Fatalf("ReadAt: got %d bytes that don't match", n)
+	}
+
+	report := zr.Report()
+	if report == nil || report.Offset != int64(n) || !errors.Is(report.Err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Report(): got %v, want offset %d", report, n)
+	}
+
+	// Fetch gets the same bytes without an error.
+	span, err = zr.Span(0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched, err := zr.Fetch(span); err != nil || !bytes.Equal(fetched, got[:n]) {
+		t.Errorf("Fetch: got %d bytes, %v, want the %d bytes before the truncation", len(fetched), err, n)
+	}
+
+	// Past the truncation looks like the end of the stream.
+	if n, err := zr.ReadAt(make([]byte, 10), report.Offset+100); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past truncation: want 0, io.EOF, got %d, %v", n, err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	var (
+		buf       bytes.Buffer
+		want      []Member
+		out       int64
+		contents  = []string{"signature", strings.Repeat("control", 100), strings.Repeat("data", 1<<16)}
+		lastStart int64
+	)
+	for i, content := range contents {
+		start := int64(buf.Len())
+		zw := gzip.NewWriter(&buf)
+		zw.Name = fmt.Sprintf("member-%d", i)
+		zw.Write([]byte(content))
+		zw.Close()
+
+		want = append(want, Member{
+			Compressed:   Range{Offset: start, Length: int64(buf.Len()) - start},
+			Decompressed: Range{Offset: out, Length: int64(len(content))},
+		})
+		out += int64(len(content))
+		lastStart = start
+	}
+	b := buf.Bytes()
+
+	zr, err := NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, io.NewSectionReader(zr, 0, out+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := zr.Members()
+	if len(got) != len(want) {
+		t.Fatalf("Members(): got %d, want %d: %+v", len(got), len(want), got)
+	}
+
+	// The last member is open ended in the index.
+	want[len(want)-1].Decompressed.Length = -1
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Members()[%d]: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if last := zr.Index().Members()[len(want)-1]; last.Compressed != (Range{Offset: lastStart, Length: -1}) {
+		t.Errorf("Index().Members(): last member should be open ended, got %+v", last)
+	}
+}
+
+// makeBGZF compresses b into 64k BGZF blocks, followed by the empty EOF block.
+func makeBGZF(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	block := func(chunk []byte) {
+		var member bytes.Buffer
+		zw := gzip.NewWriter(&member)
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		zw.OS = 255
+		zw.Write(chunk)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		mb := member.Bytes()
+		binary.LittleEndian.PutUint16(mb[16:18], uint16(len(mb)-1))
+		buf.Write(mb)
+	}
+
+	for len(b) > 0 {
+		n := min(len(b), 0xff00)
+		block(b[:n])
+		b = b[n:]
+	}
+	block(nil)
+
+	return buf.Bytes()
+}
+
+func TestBGZF(t *testing.T) {
+	want, err := os.ReadFile("./testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := makeBGZF(t, want)
+	if !IsBGZF(b) {
+		t.Fatal("IsBGZF: want true")
+	}
+
+	gz, err := os.ReadFile("./testdata/Mark.Twain-Tom.Sawyer.txt.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsBGZF(gz) {
+		t.Error("IsBGZF(plain gzip): want false")
+	}
+
+	zr, err := NewBGZFReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every block (including the empty one at the end) is a member.
+	if got, want := len(zr.Members()), (len(want)+0xff00-1)/0xff00+1; got != want {
+		t.Errorf("Members(): got %d, want %d", got, want)
+	}
+
+	size := int64(len(want))
+	for range 100 {
+		start := rand.Int64N(size)
+		end := rand.Int64N(size-start) + start
+
+		got := make([]byte, end-start)
+		if _, err := zr.ReadAt(got, start); err != nil {
+			t.Fatalf("ReadAt(%d, %d): %v", start, len(got), err)
+		}
+		if !bytes.Equal(got, want[start:end]) {
+			t.Fatalf("ReadAt(%d, %d): wrong data", start, len(got))
+		}
+	}
+
+	n, err := zr.ReadAt(make([]byte, 100), size-10)
+	if n != 10 || err != io.EOF {
+		t.Errorf("ReadAt past the end: got %d, %v; want 10, io.EOF", n, err)
+	}
+}
+
+func TestIndexStore(t *testing.T) {
+	want, err := os.ReadFile("./testdata/Mark.Twain-Tom.Sawyer.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zb, err := os.ReadFile("./testdata/Mark.Twain-Tom.Sawyer.txt.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	st := store.Dir(t.TempDir())
+
+	zr, err := NewReader(bytes.NewReader(zb), int64(len(zb)), WithIndexStore(st, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is published until we've read the whole thing.
+	if _, err := zr.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(digest, store.Gsip); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get before EOF: want ErrNotFound, got %v", err)
+	}
+
+	// Pick up where the first read left off, so the frontier reader makes it to the end.
+	if _, err := io.ReadAll(io.NewSectionReader(zr, 10, int64(len(want)))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(digest, store.Gsip); err != nil {
+		t.Fatalf("Get after EOF: %v", err)
+	}
+
+	zr, err = NewReader(bytes.NewReader(zb), int64(len(zb)), WithIndexStore(st, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.frontier != nil || len(zr.Index().Checkpoints) == 0 {
+		t.Fatal("NewReader didn't use the index from the store")
+	}
+
+	got := make([]byte, 1000)
+	if _, err := zr.ReadAt(got, 300000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[300000:301000]) {
+		t.Error("ReadAt: wrong data")
+	}
+}
+
+// countingReaderAt records the reads made against an index.
+type countingReaderAt struct {
+	ra    io.ReaderAt
+	reads []int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads = append(c.reads, len(p))
+	return c.ra.ReadAt(p, off)
+}
+
+func TestRanged(t *testing.T) {
+	want := &bytes.Buffer{}
+	for i := range 600000 {
+		fmt.Fprintf(want, "line %d: %x\n", i, i*i)
+	}
+
+	zbuf := &bytes.Buffer{}
+	zw := gzip.NewWriter(zbuf)
+	zw.Write(want.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zb := zbuf.Bytes()
+
+	zr, err := NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(io.NewSectionReader(zr, 0, int64(want.Len()))); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := zr.Index()
+	if idx.Windows() < 2 {
+		t.Fatalf("only %d windows, want a bigger stream", idx.Windows())
+	}
+
+	buf := &bytes.Buffer{}
+	if err := EncodeRanged(buf, idx, int64(len(zb))); err != nil {
+		t.Fatal(err)
+	}
+
+	index := &countingReaderAt{ra: bytes.NewReader(buf.Bytes())}
+	ranged, err := DecodeRanged(bytes.NewReader(zb), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.reads) != 2 {
+		t.Fatalf("DecodeRanged made %d reads, want 2 (header and table)", len(index.reads))
+	}
+
+	// Reading near the end should only fetch the last window, once.
+	off := int64(want.Len() - 5000)
+	for range 2 {
+		got := make([]byte, 1000)
+		if _, err := ranged.ReadAt(got, off); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Bytes()[off:off+1000]) {
+			t.Fatal("ReadAt: wrong data")
+		}
+
+		// Make sure the second time around doesn't reuse the reader from the first.
+		off -= 2000
+	}
+	if len(index.reads) != 3 || index.reads[2] != WindowSize {
+		t.Errorf("reads = %v, want one window after the header and table", index.reads)
+	}
+
+	// Fetch has to load windows too, starting from a checkpoint that ReadAt hasn't used.
+	for _, off := range []int64{int64(want.Len() / 2), int64(want.Len() - 100)} {
+		span, err := ranged.Span(off, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ranged.Fetch(span)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Bytes()[off:off+100]) {
+			t.Errorf("Fetch(%+v): wrong data", span)
+		}
+	}
+
+	bad := bytes.Clone(buf.Bytes())
+	bad[0] = 'x'
+	if _, err := DecodeRanged(bytes.NewReader(zb), bytes.NewReader(bad)); err == nil {
+		t.Error("want error for bad magic")
+	}
+
+	// A count that's bigger than the table fails when we run out of records, not when we allocate.
+	bad = bytes.Clone(buf.Bytes())
+	binary.BigEndian.PutUint32(bad[12:], maxRecords)
+	if _, err := DecodeRanged(bytes.NewReader(zb), bytes.NewReader(bad)); err == nil {
+		t.Error("want error for a count bigger than the table")
+	}
+
+	bad = bytes.Clone(buf.Bytes())
+	binary.BigEndian.PutUint32(bad[8:], rangedVersion+1)
+	if _, err := DecodeRanged(bytes.NewReader(zb), bytes.NewReader(bad)); err == nil {
+		t.Error("want error for unknown version")
+	}
+}
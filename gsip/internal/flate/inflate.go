@@ -385,7 +385,7 @@ type Decompressor struct {
 	// Jon's hacking
 	span    int64
 	last    int64
-	updates chan<- *Checkpoint
+	updates func(*Checkpoint)
 }
 
 func (f *Decompressor) nextBlock() {
@@ -790,7 +790,7 @@ func (f *Decompressor) finishBlock() {
 		}
 		copy(checkpoint.Hist, f.dict.hist)
 
-		f.updates <- checkpoint
+		f.updates(checkpoint)
 		f.last = checkpoint.Out
 	}
 	f.step = (*Decompressor).nextBlock
@@ -981,6 +981,9 @@ type Checkpoint struct {
 
 	// Optional gzip header.
 	GzipHeader *Header `json:"header,omitempty"`
+
+	// For checkpoints at the start of a gzip member, where its header starts.
+	Member int64 `json:"member,omitempty"`
 }
 
 func (c *Checkpoint) History() []byte {
@@ -1004,7 +1007,7 @@ func (c *Checkpoint) BytesWritten() int64 {
 }
 
 // NewReaderWithSpans is a hack.
-func NewReaderWithSpans(r io.Reader, span int64, start int64, updates chan<- *Checkpoint) *Decompressor {
+func NewReaderWithSpans(r io.Reader, span int64, start int64, updates func(*Checkpoint)) *Decompressor {
 	fixedHuffmanDecoderInit()
 
 	var f Decompressor
@@ -1020,7 +1023,7 @@ func NewReaderWithSpans(r io.Reader, span int64, start int64, updates chan<- *Ch
 	return &f
 }
 
-func Continue(r io.Reader, from *Checkpoint, span int64, updates chan<- *Checkpoint) *Decompressor {
+func Continue(r io.Reader, from *Checkpoint, span int64, updates func(*Checkpoint)) *Decompressor {
 	fixedHuffmanDecoderInit()
 
 	var f Decompressor
@@ -92,7 +92,7 @@ type Reader struct {
 	out     int64
 	span    int64
 	from    *flate.Checkpoint
-	updates chan *flate.Checkpoint
+	updates func(*flate.Checkpoint)
 
 	last *flate.Checkpoint
 }
@@ -104,11 +104,11 @@ type Reader struct {
 // It is the caller's responsibility to call Close on the Reader when done.
 //
 // The Reader.Header fields will be valid in the Reader returned.
-func NewReader(r io.Reader, updates chan *flate.Checkpoint) (*Reader, error) {
+func NewReader(r io.Reader, updates func(*flate.Checkpoint)) (*Reader, error) {
 	return NewReaderWithSpans(r, 1<<22, updates)
 }
 
-func NewReaderWithSpans(r io.Reader, span int64, updates chan *flate.Checkpoint) (*Reader, error) {
+func NewReaderWithSpans(r io.Reader, span int64, updates func(*flate.Checkpoint)) (*Reader, error) {
 	z := new(Reader)
 	z.span = span
 	z.updates = updates
@@ -118,7 +118,7 @@ func NewReaderWithSpans(r io.Reader, span int64, updates chan *flate.Checkpoint)
 	return z, nil
 }
 
-func Continue(r io.Reader, span int64, from *flate.Checkpoint, updates chan *flate.Checkpoint) (*Reader, error) {
+func Continue(r io.Reader, span int64, from *flate.Checkpoint, updates func(*flate.Checkpoint)) (*Reader, error) {
 	z := new(Reader)
 	z.span = span
 	z.updates = updates
@@ -223,6 +223,8 @@ func (z *Reader) readHeader() (hdr Header, err error) {
 		return hdr, nil
 	}
 
+	member := z.CompressedCount()
+
 	if _, err = io.ReadFull(z.r, z.buf[:10]); err != nil {
 		// RFC 1952, section 2.2, says the following:
 		//	A gzip file consists of a series of "members" (compressed data sets).
@@ -294,8 +296,9 @@ func (z *Reader) readHeader() (hdr Header, err error) {
 					In:         z.CompressedCount(),
 					Empty:      true,
 					GzipHeader: toFlateHeader(hdr),
+					Member:     member,
 				}
-				z.updates <- z.last
+				z.updates(z.last)
 			}
 
 			z.decompressor = flate.NewReaderWithSpans(z.r, z.span, z.CompressedCount(), z.updates)
@@ -307,8 +310,9 @@ func (z *Reader) readHeader() (hdr Header, err error) {
 				Out:        z.decompressor.Woffset(),
 				Empty:      true,
 				GzipHeader: toFlateHeader(hdr),
+				Member:     member,
 			}
-			z.updates <- z.last
+			z.updates(z.last)
 		}
 
 		z.decompressor.Reset(z.r, nil, z.CompressedCount())
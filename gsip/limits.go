@@ -0,0 +1,111 @@
+package gsip
+
+import (
+	"fmt"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+	"github.com/jonjohnsonjr/targz/store"
+)
+
+// Option configures a [Reader].
+type Option func(*options)
+
+type options struct {
+	maxDiscard int64
+	maxRatio   float64
+
+	tolerant bool
+
+	store  store.IndexStore
+	digest string
+}
+
+func makeOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxDiscard limits how many decompressed bytes a single ReadAt may throw away to get from
+// the nearest checkpoint (or reader) to the requested offset. Crossing it returns a [*DiscardLimitError].
+//
+// Zero means no limit.
+func WithMaxDiscard(n int64) Option {
+	return func(o *options) {
+		o.maxDiscard = n
+	}
+}
+
+// WithMaxRatio limits the ratio of decompressed to compressed bytes, to defend against gzip bombs.
+// Crossing it returns a [*RatioLimitError].
+//
+// The ratio is measured per decompressor and only checked once it has produced at least [MinRatioCheck] bytes,
+// since short, highly repetitive runs legitimately compress very well.
+//
+// Zero means no limit.
+func WithMaxRatio(ratio float64) Option {
+	return func(o *options) {
+		o.maxRatio = ratio
+	}
+}
+
+// MinRatioCheck is how many bytes a decompressor has to produce before [WithMaxRatio] applies.
+const MinRatioCheck = 1 << 20
+
+// DiscardLimitError is returned when reading at Offset would discard more than Limit bytes.
+type DiscardLimitError struct {
+	Offset  int64
+	Discard int64
+	Limit   int64
+}
+
+func (e *DiscardLimitError) Error() string {
+	return fmt.Sprintf("reading at %d would discard %d bytes, over the limit of %d", e.Offset, e.Discard, e.Limit)
+}
+
+// RatioLimitError is returned when the stream decompresses at a higher ratio than Limit.
+type RatioLimitError struct {
+	Compressed   int64
+	Decompressed int64
+	Limit        float64
+}
+
+func (e *RatioLimitError) Error() string {
+	return fmt.Sprintf("decompressed %d bytes from %d, over the ratio limit of %g", e.Decompressed, e.Compressed, e.Limit)
+}
+
+func (o *options) checkDiscard(off, discard int64) error {
+	if o.maxDiscard != 0 && discard > o.maxDiscard {
+		return &DiscardLimitError{
+			Offset:  off,
+			Discard: discard,
+			Limit:   o.maxDiscard,
+		}
+	}
+	return nil
+}
+
+// limited wraps a gzip.Reader to enforce the ratio limit as it decompresses.
+type limited struct {
+	zr   *gzip.Reader
+	opts *options
+}
+
+func (l limited) Read(p []byte) (int, error) {
+	n, err := l.zr.Read(p)
+
+	if l.opts.maxRatio != 0 {
+		out, in := l.zr.UncompressedCount(), l.zr.CompressedCount()
+		if out >= MinRatioCheck && float64(out) > l.opts.maxRatio*float64(max(in, 1)) {
+			return n, &RatioLimitError{
+				Compressed:   in,
+				Decompressed: out,
+				Limit:        l.opts.maxRatio,
+			}
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,47 @@
+package gsip
+
+// Member is one gzip member of a stream made by concatenating several, like an APK.
+type Member struct {
+	// Compressed covers the member's gzip header, deflate data and trailer.
+	Compressed Range
+
+	// Decompressed is what the member decompresses to.
+	Decompressed Range
+}
+
+// Members returns the gzip members discovered so far, in order.
+//
+// We don't know where the last member ends until something reads past it,
+// so its ranges are open ended.
+func (idx *Index) Members() []Member {
+	var members []Member
+	for _, cp := range idx.Checkpoints {
+		if !cp.Empty {
+			continue
+		}
+
+		if n := len(members); n != 0 {
+			last := &members[n-1]
+			last.Compressed.Length = cp.Member - last.Compressed.Offset
+			last.Decompressed.Length = cp.Out - last.Decompressed.Offset
+		}
+
+		members = append(members, Member{
+			Compressed:   Range{Offset: cp.Member, Length: -1},
+			Decompressed: Range{Offset: cp.Out, Length: -1},
+		})
+	}
+
+	return members
+}
+
+// Members is like [Index.Members] but uses the size of the blob to close the compressed range of the last member.
+func (r *Reader) Members() []Member {
+	members := r.Index().Members()
+	if n := len(members); n != 0 {
+		last := &members[n-1]
+		last.Compressed.Length = r.size - last.Compressed.Offset
+	}
+
+	return members
+}
@@ -0,0 +1,221 @@
+package gsip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/flate"
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+)
+
+// The ranged layout is meant to be read with a few small range requests instead of all at once:
+//
+//	header:      magic (8) | version (4) | count (4) | compressed size (8) | reserved (8)
+//	table:       count records of recordSize bytes, sorted by decompressed offset
+//	windows:     WindowSize bytes each, at the offset in their record
+//
+// Every integer is big endian. A record is:
+//
+//	In (8) | Out (8) | Member (8) | window offset (8, zero if none) | B (4) | NB (4) | WrPos (4) | RdPos (4) | flags (4) | reserved (4)
+//
+// So opening a Reader costs two reads (the header and the table), and every window after that is one more.
+const (
+	rangedMagic   = "gsipidx\x00"
+	rangedVersion = 1
+
+	rangedHeaderSize = 32
+	recordSize       = 56
+
+	flagEmpty = 1 << 0
+	flagFull  = 1 << 1
+)
+
+// Tables bigger than this are garbage (that's a checkpoint every 4MB for over 100TB).
+const maxRecords = 1 << 25
+
+// How many records we read from the table at a time, so a bogus count in the header
+// can't make us allocate more than the index actually has (plus one chunk).
+const recordsPerRead = 1 << 12
+
+// How many windows a Reader from DecodeRanged keeps around (2MB worth).
+const maxLoaded = 64
+
+// EncodeRanged writes idx (for a gzip stream that is size bytes long) in a layout that [DecodeRanged]
+// can read piecemeal. Gzip headers saved in the checkpoints aren't included, since reading doesn't need them.
+func EncodeRanged(w io.Writer, idx *Index, size int64) error {
+	cps := idx.Checkpoints
+	for i := 1; i < len(cps); i++ {
+		if cps[i].Out < cps[i-1].Out {
+			return fmt.Errorf("checkpoints aren't sorted: %d comes after %d", cps[i].Out, cps[i-1].Out)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+
+	hdr := make([]byte, rangedHeaderSize)
+	copy(hdr, rangedMagic)
+	binary.BigEndian.PutUint32(hdr[8:], rangedVersion)
+	binary.BigEndian.PutUint32(hdr[12:], uint32(len(cps)))
+	binary.BigEndian.PutUint64(hdr[16:], uint64(size))
+	bw.Write(hdr)
+
+	window := int64(rangedHeaderSize + recordSize*len(cps))
+	rec := make([]byte, recordSize)
+	for _, cp := range cps {
+		clear(rec)
+		binary.BigEndian.PutUint64(rec[0:], uint64(cp.In))
+		binary.BigEndian.PutUint64(rec[8:], uint64(cp.Out))
+		binary.BigEndian.PutUint64(rec[16:], uint64(cp.Member))
+		binary.BigEndian.PutUint32(rec[32:], cp.B)
+		binary.BigEndian.PutUint32(rec[36:], uint32(cp.NB))
+		binary.BigEndian.PutUint32(rec[40:], uint32(cp.WrPos))
+		binary.BigEndian.PutUint32(rec[44:], uint32(cp.RdPos))
+
+		var flags uint32
+		if cp.Empty {
+			flags |= flagEmpty
+		} else {
+			if len(cp.Hist) != WindowSize {
+				return fmt.Errorf("checkpoint at %d has a %d byte window, want %d", cp.Out, len(cp.Hist), WindowSize)
+			}
+			binary.BigEndian.PutUint64(rec[24:], uint64(window))
+			window += WindowSize
+		}
+		if cp.Full {
+			flags |= flagFull
+		}
+		binary.BigEndian.PutUint32(rec[48:], flags)
+
+		bw.Write(rec)
+	}
+
+	for _, cp := range cps {
+		if !cp.Empty {
+			bw.Write(cp.Hist)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// DecodeRanged returns a Reader for the gzip stream in ra using an index written by [EncodeRanged].
+//
+// Unlike [Decode], this only reads the header and the table of checkpoints from index up front.
+// The window for a checkpoint is read the first time something needs to start decompressing there,
+// so with something like a ranger.Reader for index, reading a few files only fetches a few windows.
+//
+// Since it doesn't have every window, the Reader's [Reader.Index] can't be used with [Decode].
+func DecodeRanged(ra io.ReaderAt, index io.ReaderAt, opts ...Option) (*Reader, error) {
+	hdr := make([]byte, rangedHeaderSize)
+	if _, err := index.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("reading index header: %w", err)
+	}
+	if string(hdr[:8]) != rangedMagic {
+		return nil, errors.New("not a ranged gsip index")
+	}
+	if v := binary.BigEndian.Uint32(hdr[8:]); v != rangedVersion {
+		return nil, fmt.Errorf("unsupported ranged index version %d", v)
+	}
+
+	count := int64(binary.BigEndian.Uint32(hdr[12:]))
+	if count > maxRecords {
+		return nil, fmt.Errorf("too many checkpoints: %d", count)
+	}
+	size := int64(binary.BigEndian.Uint64(hdr[16:]))
+
+	r := &Reader{
+		ra:          ra,
+		size:        size,
+		opts:        makeOptions(opts),
+		checkpoints: []*flate.Checkpoint{},
+		readers:     map[*gzip.Reader]bool{},
+		windows:     index,
+		windowAt:    map[*flate.Checkpoint]int64{},
+	}
+
+	var table []byte
+	for i := range count {
+		if i%recordsPerRead == 0 {
+			n := min(count-i, recordsPerRead)
+			table = make([]byte, n*recordSize)
+			if _, err := index.ReadAt(table, rangedHeaderSize+i*recordSize); err != nil {
+				return nil, fmt.Errorf("reading index table: %w", err)
+			}
+		}
+
+		j := i % recordsPerRead
+		rec := table[j*recordSize : (j+1)*recordSize]
+		flags := binary.BigEndian.Uint32(rec[48:])
+
+		cp := &flate.Checkpoint{
+			In:     int64(binary.BigEndian.Uint64(rec[0:])),
+			Out:    int64(binary.BigEndian.Uint64(rec[8:])),
+			Member: int64(binary.BigEndian.Uint64(rec[16:])),
+			B:      binary.BigEndian.Uint32(rec[32:]),
+			NB:     uint(binary.BigEndian.Uint32(rec[36:])),
+			WrPos:  int(binary.BigEndian.Uint32(rec[40:])),
+			RdPos:  int(binary.BigEndian.Uint32(rec[44:])),
+			Empty:  flags&flagEmpty != 0,
+			Full:   flags&flagFull != 0,
+		}
+
+		if n := len(r.checkpoints); n != 0 && cp.Out < r.checkpoints[n-1].Out {
+			return nil, fmt.Errorf("index table isn't sorted at record %d", i)
+		}
+
+		if !cp.Empty {
+			r.windowAt[cp] = int64(binary.BigEndian.Uint64(rec[24:]))
+		}
+
+		r.checkpoints = append(r.checkpoints, cp)
+	}
+
+	return r, nil
+}
+
+// window returns cp with its window, fetching it from the index if we haven't yet.
+func (r *Reader) window(cp *flate.Checkpoint) (*flate.Checkpoint, error) {
+	if cp.Empty || r.windows == nil {
+		return cp, nil
+	}
+
+	r.mu.Lock()
+	off, ok := r.windowAt[cp]
+	hist := r.loaded[cp]
+	r.mu.Unlock()
+
+	if !ok {
+		return cp, nil
+	}
+
+	if hist == nil {
+		hist = make([]byte, WindowSize)
+		if n, err := r.windows.ReadAt(hist, off); n != WindowSize {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("reading window for checkpoint at %d: %w", cp.Out, err)
+		}
+
+		r.mu.Lock()
+		if r.loaded == nil {
+			r.loaded = map[*flate.Checkpoint][]byte{}
+		}
+		// Evicting whichever window comes first is good enough to keep this bounded.
+		for old := range r.loaded {
+			if len(r.loaded) < maxLoaded {
+				break
+			}
+			delete(r.loaded, old)
+		}
+		r.loaded[cp] = hist
+		r.mu.Unlock()
+	}
+
+	with := *cp
+	with.Hist = hist
+	return &with, nil
+}
@@ -0,0 +1,61 @@
+package gsip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+)
+
+// Tolerant makes a [Reader] treat a truncated gzip stream as if it ended cleanly,
+// so everything before the truncation can still be read. See [Reader.Report].
+//
+// Without it, reading across the truncation fails with io.ErrUnexpectedEOF.
+func Tolerant() Option {
+	return func(o *options) {
+		o.tolerant = true
+	}
+}
+
+// Report describes where a truncated gzip stream ends.
+type Report struct {
+	// Offset is the number of decompressed bytes available before the truncation.
+	Offset int64
+
+	// Err is the error we got from the decompressor.
+	Err error
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("gzip stream truncated after %d decompressed bytes: %v", r.Offset, r.Err)
+}
+
+// Report returns where the stream was truncated, or nil if it hasn't seen a truncation.
+// This is only populated for a [Tolerant] reader, once a read has run into the end of the data.
+func (r *Reader) Report() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.report
+}
+
+// truncated returns true (and records where) if err means zr ran into the end of a truncated stream
+// and we're tolerating that.
+func (r *Reader) truncated(zr *gzip.Reader, err error) bool {
+	if !r.opts.tolerant || !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.report == nil {
+		r.report = &Report{
+			Offset: zr.Offset(),
+			Err:    err,
+		}
+	}
+
+	return true
+}
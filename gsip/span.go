@@ -0,0 +1,215 @@
+package gsip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+)
+
+// Range is a contiguous range of bytes.
+// A negative Length means "until the end".
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// End returns the offset just past the range, or -1 if it's open ended.
+func (r Range) End() int64 {
+	if r.Length < 0 {
+		return -1
+	}
+	return r.Offset + r.Length
+}
+
+// Span describes the work needed to read a range of uncompressed bytes.
+type Span struct {
+	// Checkpoint is the index of the checkpoint that decompression starts from.
+	Checkpoint int
+
+	// Compressed is the range of the gzip stream that needs to be fetched.
+	Compressed Range
+
+	// Decompressed is the range of uncompressed bytes that will be produced,
+	// starting at the checkpoint and ending at the end of the requested range.
+	// Its Length is the expected decompression cost.
+	Decompressed Range
+
+	// Discard is the number of decompressed bytes before the requested range.
+	Discard int64
+}
+
+// Span returns what it would take to read length uncompressed bytes at off.
+//
+// The end of the compressed range is the first checkpoint at or after the end of the requested range.
+// If there isn't one, the compressed range is open ended.
+func (idx *Index) Span(off, length int64) (Span, error) {
+	if off < 0 || length < 0 {
+		return Span{}, fmt.Errorf("invalid range: %d+%d", off, length)
+	}
+
+	cps := idx.Checkpoints
+	if len(cps) == 0 {
+		return Span{}, fmt.Errorf("no checkpoints")
+	}
+
+	// The last checkpoint that starts at or before off.
+	// When several share an uncompressed offset (e.g. gzip member boundaries), this picks the latest.
+	start := sort.Search(len(cps), func(i int) bool {
+		return cps[i].Out > off
+	}) - 1
+	if start < 0 {
+		return Span{}, fmt.Errorf("no checkpoint before offset %d", off)
+	}
+
+	end := off + length
+	from := cps[start]
+
+	span := Span{
+		Checkpoint: start,
+		Compressed: Range{
+			Offset: from.In,
+			Length: -1,
+		},
+		Decompressed: Range{
+			Offset: from.Out,
+			Length: end - from.Out,
+		},
+		Discard: off - from.Out,
+	}
+
+	for _, cp := range cps[start+1:] {
+		if cp.Out >= end {
+			span.Compressed.Length = cp.In - from.In
+			break
+		}
+	}
+
+	return span, nil
+}
+
+// Span is like [Index.Span] but uses the size of the blob to close open ended ranges.
+func (r *Reader) Span(off, length int64) (Span, error) {
+	span, err := r.Index().Span(off, length)
+	if err != nil {
+		return span, err
+	}
+
+	if span.Compressed.Length < 0 {
+		span.Compressed.Length = r.size - span.Compressed.Offset
+	}
+
+	return span, nil
+}
+
+// Merge sorts spans by compressed offset and combines any that overlap or touch,
+// since decompressing from the earlier checkpoint covers both.
+// A merged span's Discard is the smallest of its parts, so the result covers every requested range.
+func Merge(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sorted := make([]Span, len(spans))
+	copy(sorted, spans)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Compressed.Offset != sorted[j].Compressed.Offset {
+			return sorted[i].Compressed.Offset < sorted[j].Compressed.Offset
+		}
+		return sorted[i].Discard < sorted[j].Discard
+	})
+
+	merged := []Span{sorted[0]}
+	for _, span := range sorted[1:] {
+		last := &merged[len(merged)-1]
+
+		lastEnd := last.Compressed.End()
+		if lastEnd >= 0 && span.Compressed.Offset > lastEnd {
+			merged = append(merged, span)
+			continue
+		}
+
+		if lastEnd >= 0 {
+			if end := span.Compressed.End(); end < 0 {
+				last.Compressed.Length = -1
+			} else if end > lastEnd {
+				last.Compressed.Length = end - last.Compressed.Offset
+			}
+		}
+
+		if discard := span.Decompressed.Offset + span.Discard - last.Decompressed.Offset; discard < last.Discard {
+			last.Discard = discard
+		}
+
+		if end := span.Decompressed.End(); end > last.Decompressed.End() {
+			last.Decompressed.Length = end - last.Decompressed.Offset
+		}
+	}
+
+	return merged
+}
+
+// Fetch reads the compressed bytes for span with a single ReadAt and decompresses them in memory,
+// returning the uncompressed bytes of the requested range (everything after Discard).
+//
+// This is useful for prefetching over something like ranger, where each ReadAt is a round trip.
+func (r *Reader) Fetch(span Span) ([]byte, error) {
+	r.mu.Lock()
+	if span.Checkpoint < 0 || span.Checkpoint >= len(r.checkpoints) {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("fetching span: unknown checkpoint %d", span.Checkpoint)
+	}
+	from := r.checkpoints[span.Checkpoint]
+	r.mu.Unlock()
+
+	if from.In != span.Compressed.Offset || from.Out != span.Decompressed.Offset {
+		return nil, fmt.Errorf("fetching span: checkpoint %d is at %d/%d, not %d/%d", span.Checkpoint, from.In, from.Out, span.Compressed.Offset, span.Decompressed.Offset)
+	}
+
+	if err := r.opts.checkDiscard(span.Decompressed.Offset+span.Discard, span.Discard); err != nil {
+		return nil, err
+	}
+
+	length := span.Compressed.Length
+	if length < 0 {
+		length = r.size - span.Compressed.Offset
+	}
+
+	compressed := make([]byte, length)
+	n, err := r.ra.ReadAt(compressed, span.Compressed.Offset)
+	if err != nil && !(err == io.EOF && n == len(compressed)) {
+		return nil, fmt.Errorf("fetching %d bytes at %d: %w", length, span.Compressed.Offset, err)
+	}
+
+	// A Reader from DecodeRanged doesn't have the window until we ask for it.
+	from, err = r.window(from)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.Continue(bytes.NewReader(compressed), 0, from, nil)
+	if err != nil {
+		return nil, fmt.Errorf("continue: %w", err)
+	}
+
+	lr := r.limit(zr)
+	if _, err := io.CopyN(io.Discard, lr, span.Discard); err != nil {
+		r.truncated(zr, err)
+		return nil, fmt.Errorf("discarding %d bytes: %w", span.Discard, err)
+	}
+
+	out := make([]byte, span.Decompressed.Length-span.Discard)
+	n, err = readFull(lr, out)
+	if err == io.EOF || r.truncated(zr, err) {
+		// The end of the stream (or as much of it as there is), so this is all there is.
+		return out[:n], nil
+	}
+	if err != nil {
+		// This includes io.ErrUnexpectedEOF for a truncated stream, unless we're tolerant.
+		return nil, fmt.Errorf("decompressing span: %w", err)
+	}
+
+	return out, nil
+}
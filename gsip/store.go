@@ -0,0 +1,58 @@
+package gsip
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+	"github.com/jonjohnsonjr/targz/store"
+)
+
+// WithIndexStore makes [NewReader] (and [NewBGZFReader]) use the index for digest (the digest of the compressed blob) from s, if there is one.
+// Otherwise, the index is published to s once the stream has been read to the end by the first reader,
+// which is what happens when something like [tarfs.New] reads the whole stream in order.
+//
+// The store is only a cache, so failing to get from it or put to it isn't an error.
+//
+// [tarfs.New]: https://pkg.go.dev/github.com/jonjohnsonjr/targz/tarfs#New
+func WithIndexStore(s store.IndexStore, digest string) Option {
+	return func(o *options) {
+		o.store = s
+		o.digest = digest
+	}
+}
+
+// fromStore loads the index from our store, if it has one.
+func (r *Reader) fromStore() bool {
+	b, err := r.opts.store.Get(r.opts.digest, store.Gsip)
+	if err != nil {
+		return false
+	}
+
+	idx := Index{}
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return false
+	}
+
+	r.checkpoints = idx.Checkpoints
+	return true
+}
+
+// publish puts our index in the store once the frontier reader has reached the end of the stream,
+// which is the only time we know the index is complete.
+func (r *Reader) publish(zr *gzip.Reader) {
+	if r.opts.store == nil || zr != r.frontier {
+		return
+	}
+
+	r.published.Do(r.put)
+}
+
+// put encodes our index and puts it in the store.
+func (r *Reader) put() {
+	buf := &bytes.Buffer{}
+	if err := r.Encode(buf); err != nil {
+		return
+	}
+	r.opts.store.Put(r.opts.digest, store.Gsip, buf.Bytes())
+}
@@ -0,0 +1,81 @@
+package gsip
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jonjohnsonjr/targz/gsip/internal/flate"
+	"github.com/jonjohnsonjr/targz/gsip/internal/gzip"
+)
+
+// WindowSize is the size of the window saved with every checkpoint, except the ones
+// at the start of a gzip member, which don't need one.
+//
+// Windows are almost all of the size of an index, so [Index.Split] lets you store them separately.
+const WindowSize = 32 << 10
+
+// Split returns a copy of idx without its windows, along with the windows of the checkpoints that have one, in order.
+// Every window is [WindowSize] bytes.
+func (idx *Index) Split() (*Index, [][]byte) {
+	stripped := &Index{Checkpoints: make([]*flate.Checkpoint, 0, len(idx.Checkpoints))}
+
+	var windows [][]byte
+	for _, cp := range idx.Checkpoints {
+		if !cp.Empty {
+			windows = append(windows, cp.Hist)
+		}
+
+		c := *cp
+		c.Hist = nil
+		stripped.Checkpoints = append(stripped.Checkpoints, &c)
+	}
+
+	return stripped, windows
+}
+
+// Windows returns how many windows idx has (or needs, if it came from [Index.Split]).
+func (idx *Index) Windows() int {
+	n := 0
+	for _, cp := range idx.Checkpoints {
+		if !cp.Empty {
+			n++
+		}
+	}
+	return n
+}
+
+// Join is the inverse of [Index.Split].
+func (idx *Index) Join(windows [][]byte) (*Index, error) {
+	if want := idx.Windows(); len(windows) != want {
+		return nil, fmt.Errorf("index needs %d windows, got %d", want, len(windows))
+	}
+
+	joined := &Index{Checkpoints: make([]*flate.Checkpoint, 0, len(idx.Checkpoints))}
+
+	for _, cp := range idx.Checkpoints {
+		c := *cp
+		if !c.Empty {
+			if len(windows[0]) != WindowSize {
+				return nil, fmt.Errorf("window for checkpoint at %d is %d bytes, want %d", c.Out, len(windows[0]), WindowSize)
+			}
+			c.Hist = windows[0]
+			windows = windows[1:]
+		}
+		joined.Checkpoints = append(joined.Checkpoints, &c)
+	}
+
+	return joined, nil
+}
+
+// FromIndex returns a Reader for the gzip stream in ra (which is size bytes long) that uses the checkpoints in idx.
+// Like a Reader from [Decode], it won't discover any checkpoints that idx doesn't have.
+func FromIndex(ra io.ReaderAt, size int64, idx *Index, opts ...Option) *Reader {
+	return &Reader{
+		ra:          ra,
+		size:        size,
+		opts:        makeOptions(opts),
+		checkpoints: slices.Clone(idx.Checkpoints),
+		readers:     map[*gzip.Reader]bool{},
+	}
+}
@@ -0,0 +1,242 @@
+package targz
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// IndexVersion is the version of the format written by [EncodeIndex].
+const IndexVersion = 1
+
+// indexMagic starts every index written by [EncodeIndex].
+const indexMagic = "targzidx"
+
+// Headers bigger than this are garbage.
+const maxIndexHeader = 1 << 20
+
+// IndexHeader describes an index written by [EncodeIndex]: which blob it's for and where its sections are.
+//
+// The layout is:
+//
+//	"targzidx" | version (uint32) | header length (uint32) | header (JSON) | TOC | checkpoints | windows
+//
+// with integers in big endian. The TOC is a tarfs.TOC and the checkpoints are a gsip.Index without
+// its windows, both as JSON. The windows (each [gsip.WindowSize] bytes) come last, so everything
+// needed to list the files is at the front and is usually a small fraction of the index.
+type IndexHeader struct {
+	Version int `json:"-"`
+
+	// Digest is the digest of the compressed blob, e.g. "sha256:...".
+	Digest string
+
+	CompressedSize   int64
+	UncompressedSize int64
+
+	// Lengths of the TOC and checkpoints sections.
+	TOC         int64
+	Checkpoints int64
+
+	// Windows is how many windows there are.
+	Windows    int
+	WindowSize int
+}
+
+// EncodeIndex writes one index with everything needed to serve fsys over zr:
+// the tarfs TOC, the gsip checkpoints and windows, and the digest and sizes of the blob.
+//
+// Every window has to be there, so this fails for a zr that doesn't have them (e.g. one from [gsip.DecodeRanged]).
+func EncodeIndex(w io.Writer, digest string, zr *gsip.Reader, fsys *tarfs.FS) error {
+	toc := &bytes.Buffer{}
+	if err := fsys.Encode(toc); err != nil {
+		return fmt.Errorf("encoding TOC: %w", err)
+	}
+
+	idx, windows := zr.Index().Split()
+	for i, window := range windows {
+		if len(window) != gsip.WindowSize {
+			return fmt.Errorf("window %d is %d bytes, want %d", i, len(window), gsip.WindowSize)
+		}
+	}
+
+	checkpoints, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoints: %w", err)
+	}
+
+	hdr, err := json.Marshal(&IndexHeader{
+		Digest:           digest,
+		CompressedSize:   zr.CompressedSize(),
+		UncompressedSize: fsys.Size(),
+		TOC:              int64(toc.Len()),
+		Checkpoints:      int64(len(checkpoints)),
+		Windows:          len(windows),
+		WindowSize:       gsip.WindowSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(indexMagic)
+	binary.Write(bw, binary.BigEndian, uint32(IndexVersion))
+	binary.Write(bw, binary.BigEndian, uint32(len(hdr)))
+	bw.Write(hdr)
+	bw.Write(toc.Bytes())
+	bw.Write(checkpoints)
+	for _, window := range windows {
+		bw.Write(window)
+	}
+
+	return bw.Flush()
+}
+
+// ReadIndexHeader reads the header of an index written by [EncodeIndex], leaving r at the start of the TOC.
+func ReadIndexHeader(r io.Reader) (*IndexHeader, error) {
+	prefix := make([]byte, len(indexMagic)+8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("reading index header: %w", err)
+	}
+	if string(prefix[:len(indexMagic)]) != indexMagic {
+		return nil, errors.New("not a targz index")
+	}
+
+	version := binary.BigEndian.Uint32(prefix[len(indexMagic):])
+	if version != IndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+
+	n := binary.BigEndian.Uint32(prefix[len(indexMagic)+4:])
+	if n > maxIndexHeader {
+		return nil, fmt.Errorf("index header is too big: %d bytes", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("reading index header: %w", err)
+	}
+
+	hdr := &IndexHeader{}
+	if err := json.Unmarshal(b, hdr); err != nil {
+		return nil, fmt.Errorf("decoding index header: %w", err)
+	}
+	hdr.Version = int(version)
+
+	if hdr.TOC < 0 || hdr.Checkpoints < 0 || hdr.Windows < 0 {
+		return nil, fmt.Errorf("invalid index header: %+v", hdr)
+	}
+	if hdr.Windows != 0 && hdr.WindowSize != gsip.WindowSize {
+		return nil, fmt.Errorf("unsupported window size %d", hdr.WindowSize)
+	}
+
+	return hdr, nil
+}
+
+// DecodeIndex reads an index written by [EncodeIndex] and returns an FS for the compressed blob in ra.
+// opts are passed to [tarfs.Decode].
+//
+// This doesn't read ra, so it's up to the caller to make sure the index is for that blob (see [IndexHeader.Digest]).
+func DecodeIndex(ra io.ReaderAt, r io.Reader, opts ...tarfs.Option) (*tarfs.FS, *IndexHeader, error) {
+	hdr, toc, err := readTOC(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := readSection(r, hdr.Checkpoints)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading checkpoints: %w", err)
+	}
+
+	idx := &gsip.Index{}
+	if err := json.Unmarshal(b, idx); err != nil {
+		return nil, nil, fmt.Errorf("decoding checkpoints: %w", err)
+	}
+
+	// Don't trust the header's count enough to allocate it.
+	if want := idx.Windows(); hdr.Windows != want {
+		return nil, nil, fmt.Errorf("index header says %d windows, checkpoints need %d", hdr.Windows, want)
+	}
+
+	windows := make([][]byte, hdr.Windows)
+	for i := range windows {
+		if windows[i], err = readSection(r, int64(hdr.WindowSize)); err != nil {
+			return nil, nil, fmt.Errorf("reading window %d: %w", i, err)
+		}
+	}
+
+	if idx, err = idx.Join(windows); err != nil {
+		return nil, nil, err
+	}
+
+	zr := gsip.FromIndex(ra, hdr.CompressedSize, idx)
+
+	fsys, err := tarfs.Decode(zr, bytes.NewReader(toc), opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding TOC: %w", err)
+	}
+
+	return fsys, hdr, nil
+}
+
+// DecodeIndexTOC reads just the header and TOC of an index written by [EncodeIndex], without the checkpoints or windows.
+//
+// The returned FS can list and stat files, but reading their contents fails with [ErrNoWindows].
+func DecodeIndexTOC(r io.Reader) (*tarfs.FS, *IndexHeader, error) {
+	hdr, toc, err := readTOC(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fsys, err := tarfs.Decode(noWindows{}, bytes.NewReader(toc))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding TOC: %w", err)
+	}
+
+	return fsys, hdr, nil
+}
+
+// ErrNoWindows is returned when reading files from an FS returned by [DecodeIndexTOC].
+var ErrNoWindows = errors.New("index was decoded without its windows")
+
+type noWindows struct{}
+
+func (noWindows) ReadAt([]byte, int64) (int, error) {
+	return 0, ErrNoWindows
+}
+
+func readTOC(r io.Reader) (*IndexHeader, []byte, error) {
+	hdr, err := ReadIndexHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toc, err := readSection(r, hdr.TOC)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading TOC: %w", err)
+	}
+
+	return hdr, toc, nil
+}
+
+// readSection reads exactly n bytes, without trusting n enough to allocate it all up front.
+func readSection(r io.Reader, n int64) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if _, err := io.CopyN(buf, r, n); err != nil {
+		return nil, noEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
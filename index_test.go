@@ -0,0 +1,131 @@
+package targz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// bigTarGz returns a tar.gz that's big enough for gsip to checkpoint a few times.
+func bigTarGz(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for i := range 4 {
+		content := &bytes.Buffer{}
+		for j := range 200000 {
+			fmt.Fprintf(content, "file %d line %d: %x\n", i, j, j*j*(i+1))
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file-%d.txt", i), Mode: 0o644, Size: int64(content.Len())}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content.Bytes())
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestIndex(t *testing.T) {
+	blob := bigTarGz(t)
+
+	zr, err := gsip.NewReader(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := tarfs.New(zr, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := EncodeIndex(buf, "sha256:abc", zr, want); err != nil {
+		t.Fatal(err)
+	}
+	index := buf.Bytes()
+
+	got, hdr, err := DecodeIndex(bytes.NewReader(blob), bytes.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hdr.Version != IndexVersion || hdr.Digest != "sha256:abc" || hdr.CompressedSize != int64(len(blob)) || hdr.UncompressedSize != want.Size() {
+		t.Errorf("header: got %+v", hdr)
+	}
+	if hdr.Windows == 0 {
+		t.Errorf("want some windows, got none")
+	}
+
+	compare(t, want, got)
+
+	// The TOC is readable without the rest, so a truncated index is enough to list files.
+	toc, hdr, err := DecodeIndexTOC(bytes.NewReader(index[:len(index)-hdr.Windows*hdr.WindowSize]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := fs.Glob(toc, "file-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 {
+		t.Errorf("Glob: got %q", names)
+	}
+	if _, err := fs.ReadFile(toc, "file-0.txt"); !errors.Is(err, ErrNoWindows) {
+		t.Errorf("ReadFile without windows: want ErrNoWindows, got %v", err)
+	}
+
+	// But DecodeIndex needs everything.
+	if _, _, err := DecodeIndex(bytes.NewReader(blob), bytes.NewReader(index[:len(index)-1])); err == nil {
+		t.Error("DecodeIndex(truncated): want error")
+	}
+
+	// A header that claims more windows than the checkpoints need is rejected before we allocate them.
+	lying := &bytes.Buffer{}
+	r := bytes.NewReader(index)
+	lhdr, err := ReadIndexHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lhdr.Windows = 1 << 40
+	b, err := json.Marshal(lhdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lying.WriteString(indexMagic)
+	binary.Write(lying, binary.BigEndian, uint32(IndexVersion))
+	binary.Write(lying, binary.BigEndian, uint32(len(b)))
+	lying.Write(b)
+	io.Copy(lying, r)
+	if _, _, err := DecodeIndex(bytes.NewReader(blob), lying); err == nil {
+		t.Error("DecodeIndex(too many windows): want error")
+	}
+
+	// A Reader without its windows can't be encoded.
+	stripped, _ := zr.Index().Split()
+	if err := EncodeIndex(io.Discard, "sha256:abc", gsip.FromIndex(bytes.NewReader(blob), int64(len(blob)), stripped), want); err == nil {
+		t.Error("EncodeIndex(no windows): want error")
+	}
+
+	bad := bytes.Clone(index)
+	bad[len(indexMagic)+3] = 99
+	if _, err := ReadIndexHeader(bytes.NewReader(bad)); err == nil {
+		t.Error("ReadIndexHeader(version 99): want error")
+	}
+}
@@ -0,0 +1,99 @@
+package oci
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/store"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// WithLayerOptions passes opts to [tarfs.New] for each layer opened by [LoadLayout].
+// [Load] ignores this, since layers inherit the options of the outer tarball.
+func WithLayerOptions(opts ...tarfs.Option) Option {
+	return func(o *options) {
+		o.layer = append(o.layer, opts...)
+	}
+}
+
+// LoadLayout finds an image in an OCI image layout directory.
+//
+// The first time a layer blob is opened, its gsip index (if it's gzipped) and tarfs TOC are written
+// next to it as "<digest>.gsip" and "<digest>.tarfs" (i.e. "blobs" is a [store.Dir]) so that loading it again
+// doesn't have to decompress anything. Since blobs are content-addressed, these never go stale.
+// If dir isn't writable, the indexes are just rebuilt every time.
+//
+// The Image holds the layer blobs open until it's closed.
+func LoadLayout(dir string, opts ...Option) (*Image, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	fsys := os.DirFS(dir)
+	if _, err := fs.Stat(fsys, "oci-layout"); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %w", dir, err)
+	}
+
+	var (
+		closers []io.Closer
+		indexes = store.Dir(filepath.Join(dir, "blobs"))
+	)
+	open := func(p string) (*tarfs.FS, error) {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, f)
+
+		// p is "blobs/<algorithm>/<hex>".
+		digest := path.Base(path.Dir(p)) + ":" + path.Base(p)
+
+		return openBlob(f, indexes, digest, o.layer)
+	}
+
+	img, err := load(fsys, open, o)
+	if err != nil {
+		for _, c := range closers {
+			c.Close()
+		}
+		return nil, err
+	}
+
+	img.closers = closers
+
+	return img, nil
+}
+
+// openBlob indexes a layer blob, reusing and saving indexes in s.
+func openBlob(f *os.File, s store.IndexStore, digest string, opts []tarfs.Option) (*tarfs.FS, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ra   io.ReaderAt = f
+		size             = info.Size()
+	)
+
+	magic := make([]byte, 2)
+	if _, err := f.ReadAt(magic, 0); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gsip.NewReader(f, size, gsip.WithIndexStore(s, digest))
+		if err != nil {
+			return nil, err
+		}
+
+		// We don't know the uncompressed size.
+		ra, size = zr, -1
+	}
+
+	return tarfs.New(ra, size, append(slices.Clip(opts), tarfs.WithIndexStore(s, digest))...)
+}
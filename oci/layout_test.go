@@ -0,0 +1,76 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/store"
+)
+
+func TestLoadLayout(t *testing.T) {
+	dir := t.TempDir()
+	for _, e := range ociLayout(t) {
+		p := filepath.Join(dir, filepath.FromSlash(e.name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(e.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img, err := LoadLayout(dir, WithRef("latest"), WithPlatform("linux/amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, img)
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the gzipped layer gets a gsip index.
+	var indexes []string
+	for _, l := range img.Layers {
+		blob := filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(l.Digest, "sha256:"))
+		for _, suffix := range []string{"." + string(store.Gsip), "." + string(store.TOC)} {
+			if _, err := os.Stat(blob + suffix); err == nil {
+				indexes = append(indexes, blob+suffix)
+			}
+		}
+	}
+	if len(indexes) != 3 {
+		t.Fatalf("want 3 indexes next to the blobs, got %v", indexes)
+	}
+
+	// Loading again should reuse the indexes rather than rewrite them.
+	old := time.Unix(0, 0)
+	for _, idx := range indexes {
+		if err := os.Chtimes(idx, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	img, err = LoadLayout(dir, WithRef("latest"), WithPlatform("linux/amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	checkImage(t, img)
+
+	for _, idx := range indexes {
+		info, err := os.Stat(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("%s was rewritten", idx)
+		}
+	}
+
+	if _, err := LoadLayout(t.TempDir()); err == nil {
+		t.Errorf("LoadLayout(empty dir): want error")
+	}
+}
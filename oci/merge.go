@@ -0,0 +1,314 @@
+package oci
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// FS is a read-only, whiteout-aware union of layers, the way an overlay filesystem would present them.
+//
+// Like [tarfs.FS], symlinks are resolved as if the root of the image were a chroot.
+type FS struct {
+	files map[string]file
+	dirs  map[string][]fs.DirEntry
+}
+
+var (
+	_ fs.ReadDirFS = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+)
+
+// file is the topmost entry for a name and the layer it came from.
+type file struct {
+	entry *tarfs.Entry
+	layer *tarfs.FS
+}
+
+// Merge stacks layers on top of each other, from the bottom up.
+//
+// A ".wh.<name>" entry hides <name> (and anything under it) in lower layers, and a ".wh..wh..opq" entry
+// hides everything in lower layers under its directory. Whiteouts themselves don't show up in the result.
+func Merge(layers ...*tarfs.FS) *FS {
+	fsys := &FS{
+		files: map[string]file{},
+		dirs:  map[string][]fs.DirEntry{".": nil},
+	}
+
+	for _, layer := range layers {
+		// Whiteouts only apply to lower layers, so handle them before adding anything from this one.
+		// Directories whose contents are hidden are collected so we only go through the files once per layer.
+		hidden := map[string]bool{}
+		for e := range layer.Entries() {
+			dir, base := path.Split(e.Filename)
+			dir = path.Clean(dir)
+
+			switch {
+			case base == whiteoutOpaque:
+				hidden[dir] = true
+			case strings.HasPrefix(base, whiteoutPrefix):
+				name := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				delete(fsys.files, name)
+				hidden[name] = true
+			default:
+				// Replacing a directory with anything else hides what was in it.
+				if old, ok := fsys.files[e.Filename]; ok && old.entry.IsDir() && !e.IsDir() {
+					hidden[e.Filename] = true
+				}
+			}
+		}
+		fsys.removeUnder(hidden)
+
+		for e := range layer.Entries() {
+			if e.Filename == "." || strings.HasPrefix(path.Base(e.Filename), whiteoutPrefix) {
+				continue
+			}
+
+			fsys.files[e.Filename] = file{entry: e, layer: layer}
+		}
+	}
+
+	for name, f := range fsys.files {
+		fsys.addDir(path.Dir(name), f.entry)
+	}
+
+	for _, des := range fsys.dirs {
+		slices.SortFunc(des, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+
+	return fsys
+}
+
+// removeUnder removes everything under any of dirs, but not the dirs themselves.
+func (fsys *FS) removeUnder(dirs map[string]bool) {
+	if len(dirs) == 0 {
+		return
+	}
+
+	if dirs["."] {
+		clear(fsys.files)
+		return
+	}
+
+	for name := range fsys.files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if dirs[dir] {
+				delete(fsys.files, name)
+				break
+			}
+		}
+	}
+}
+
+// addDir adds de to the listing for dir, creating any implicit parent directories along the way.
+func (fsys *FS) addDir(dir string, de fs.DirEntry) {
+	_, seen := fsys.dirs[dir]
+	fsys.dirs[dir] = append(fsys.dirs[dir], de)
+
+	if seen || dir == "." {
+		return
+	}
+
+	if _, ok := fsys.files[dir]; !ok {
+		fsys.addDir(path.Dir(dir), tarfs.ImplicitDir(path.Base(dir)))
+	}
+}
+
+// Entry returns the topmost entry for name, without following any symlinks, and the layer it came from.
+func (fsys *FS) Entry(name string) (*tarfs.Entry, *tarfs.FS, error) {
+	resolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: "entry", Path: name, Err: err}
+	}
+
+	f, ok := fsys.files[resolved]
+	if !ok {
+		return nil, nil, &fs.PathError{Op: "entry", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return f.entry, f.layer, nil
+}
+
+// lookup returns the info for an already-resolved name.
+func (fsys *FS) lookup(name string) (fs.FileInfo, bool) {
+	fi, _, ok := fsys.lookupLink(name)
+	return fi, ok
+}
+
+// lookupLink is lookup as a [tarfs.LookupFunc].
+func (fsys *FS) lookupLink(name string) (fs.FileInfo, string, bool) {
+	if f, ok := fsys.files[name]; ok {
+		info, _ := f.entry.Info()
+		if f.entry.Header.Typeflag != tar.TypeSymlink {
+			return info, "", true
+		}
+		return info, f.entry.Header.Linkname, true
+	}
+
+	if _, ok := fsys.dirs[name]; ok {
+		return tarfs.ImplicitDir(path.Base(name)), "", true
+	}
+
+	return nil, "", false
+}
+
+// resolve resolves name in the merged view the same way tarfs does.
+func (fsys *FS) resolve(name string, follow bool) (string, error) {
+	return tarfs.Resolve(name, follow, fsys.lookupLink)
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.open(name, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return f, nil
+}
+
+func (fsys *FS) open(name string, hops int) (fs.File, error) {
+	if hops > tarfs.MaxHops {
+		return nil, fmt.Errorf("opening %s: chased too many (%d) hardlinks", name, tarfs.MaxHops)
+	}
+
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	if fi.IsDir() {
+		return &dir{fi: fi, entries: fsys.dirs[resolved]}, nil
+	}
+
+	f := fsys.files[resolved]
+
+	// Hardlinks may point into a lower layer, so chase them here instead of in the layer.
+	if f.entry.Header.Typeflag == tar.TypeLink {
+		return fsys.open(strings.TrimPrefix(path.Clean(f.entry.Header.Linkname), "/"), hops+1)
+	}
+
+	return f.layer.Open(f.entry.Filename)
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// Lstat is like Stat but doesn't follow a symlink in the last component of name.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+
+	fi, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fi, nil
+}
+
+// ReadLink returns the target of the symlink at name.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	e, _, err := fsys.Entry(name)
+	if err != nil {
+		return "", err
+	}
+
+	if e.Header.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.New("not a symlink")}
+	}
+
+	return e.Header.Linkname, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	fi, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return slices.Clone(fsys.dirs[resolved]), nil
+}
+
+// dir is an open directory in the merged view.
+type dir struct {
+	fi      fs.FileInfo
+	entries []fs.DirEntry
+
+	// current position in readdir listing
+	cursor int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.fi, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.fi.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.cursor:]
+	if n <= 0 {
+		d.cursor = len(d.entries)
+		return slices.Clone(rest), nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(rest))
+	d.cursor += n
+
+	return slices.Clone(rest[:n]), nil
+}
@@ -0,0 +1,390 @@
+// Package oci reads container images out of `docker save` and `oci-archive` tarballs without extracting them,
+// or out of OCI image layout directories on disk.
+//
+// Each layer is opened in place as a [tarfs.FS] (through a [gsip.Reader] if it's gzipped),
+// and [Image.FS] merges them into the root filesystem a container would see.
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Media types we know how to walk through.
+const (
+	MediaTypeIndex        = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerSchema = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Annotations that name an image in an index.
+const (
+	AnnotationRefName   = "org.opencontainers.image.ref.name"
+	AnnotationImageName = "io.containerd.image.name"
+)
+
+// Descriptor points at a blob.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform is the os and architecture of an image in an index.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p *Platform) String() string {
+	if p == nil {
+		return ""
+	}
+
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Index is an OCI image index or a docker manifest list.
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest or a docker v2 schema 2 manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Config is the subset of the image config that's useful for poking around an image.
+// [Image.RawConfig] has the rest.
+type Config struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant,omitempty"`
+	Config       struct {
+		User       string            `json:"User,omitempty"`
+		Env        []string          `json:"Env,omitempty"`
+		Entrypoint []string          `json:"Entrypoint,omitempty"`
+		Cmd        []string          `json:"Cmd,omitempty"`
+		WorkingDir string            `json:"WorkingDir,omitempty"`
+		Labels     map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []History `json:"history,omitempty"`
+}
+
+// History is one step of how the image was built.
+type History struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// Image is a single image, with every layer already indexed.
+type Image struct {
+	// Manifest is synthesized from manifest.json for docker save tarballs without an index.json.
+	Manifest  *Manifest
+	Config    *Config
+	RawConfig []byte
+	Layers    []*Layer
+
+	// Anything backing the layers that we opened ourselves.
+	closers []io.Closer
+}
+
+// Close releases any files held open for the layers.
+func (img *Image) Close() error {
+	var errs []error
+	for _, c := range img.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Layer is one layer of an image.
+type Layer struct {
+	Descriptor
+
+	// FS is the contents of the layer, including any whiteouts.
+	FS *tarfs.FS
+}
+
+// FS returns the merged root filesystem of the image. See [Merge].
+func (img *Image) FS() *FS {
+	layers := make([]*tarfs.FS, len(img.Layers))
+	for i, l := range img.Layers {
+		layers[i] = l.FS
+	}
+	return Merge(layers...)
+}
+
+// Option configures [Load] and [LoadLayout].
+type Option func(*options)
+
+type options struct {
+	ref      string
+	platform string
+	layer    []tarfs.Option
+}
+
+// WithRef picks the image named ref, if the tarball has more than one.
+// This matches a tag from `docker save`, an [AnnotationRefName] or [AnnotationImageName], or a manifest digest.
+func WithRef(ref string) Option {
+	return func(o *options) {
+		o.ref = ref
+	}
+}
+
+// WithPlatform picks the "os/arch" or "os/arch/variant" image from a multi-platform index.
+func WithPlatform(platform string) Option {
+	return func(o *options) {
+		o.platform = platform
+	}
+}
+
+// Load finds an image in a `docker save` or `oci-archive` tarball.
+//
+// If the tarball has an index.json, it's used to find the manifest. Otherwise we fall back to
+// the manifest.json written by `docker save`. Either way, layers are opened with [tarfs.FS.OpenArchive],
+// so they share the options of fsys.
+func Load(fsys *tarfs.FS, opts ...Option) (*Image, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if _, err := fsys.Stat("index.json"); err == nil {
+		return load(fsys, fsys.OpenArchive, o)
+	}
+
+	return loadDocker(fsys, o)
+}
+
+// load resolves an image through index.json, opening each layer with open.
+func load(fsys fs.FS, open func(string) (*tarfs.FS, error), o *options) (*Image, error) {
+	idx := &Index{}
+	if err := readJSON(fsys, "index.json", idx); err != nil {
+		return nil, err
+	}
+
+	desc, err := pick(idx.Manifests, o.ref, func(d Descriptor) []string {
+		return []string{d.Digest, d.Annotations[AnnotationRefName], d.Annotations[AnnotationImageName]}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("index.json: %w", err)
+	}
+
+	m, err := Resolve(desc, o.platform, func(d Descriptor) ([]byte, error) {
+		p, err := blobPath(d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return fs.ReadFile(fsys, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := blobPath(m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]string, len(m.Layers))
+	for i, l := range m.Layers {
+		if layers[i], err = blobPath(l.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	return build(fsys, open, m, config, layers)
+}
+
+// Resolve walks from desc through any indexes until it finds an image manifest, using fetch to read
+// each index or manifest. If an index has more than one image, platform ("os/arch" or "os/arch/variant") picks one.
+func Resolve(desc Descriptor, platform string, fetch func(Descriptor) ([]byte, error)) (*Manifest, error) {
+	// Enough to tell an index from a manifest without trusting the descriptor.
+	var probe struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+
+	b, err := fetch(desc)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", desc.Digest, err)
+	}
+
+	switch {
+	case probe.MediaType == MediaTypeIndex || probe.MediaType == MediaTypeDockerList || probe.Manifests != nil:
+		idx := &Index{}
+		if err := json.Unmarshal(b, idx); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", desc.Digest, err)
+		}
+
+		// Skip attestations and anything else that isn't for a real platform.
+		manifests := slices.DeleteFunc(idx.Manifests, func(d Descriptor) bool {
+			return d.Platform != nil && d.Platform.OS == "unknown"
+		})
+
+		next, err := pick(manifests, platform, func(d Descriptor) []string {
+			// Entries don't have to say what platform they're for.
+			if d.Platform == nil {
+				return []string{""}
+			}
+			return []string{d.Platform.String(), strings.TrimSuffix(d.Platform.String(), "/"+d.Platform.Variant)}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", desc.Digest, err)
+		}
+
+		return Resolve(next, platform, fetch)
+	default:
+		m := &Manifest{}
+		if err := json.Unmarshal(b, m); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", desc.Digest, err)
+		}
+		return m, nil
+	}
+}
+
+// pick returns the one descriptor matching want, or the only descriptor if want is empty.
+func pick(descs []Descriptor, want string, names func(Descriptor) []string) (Descriptor, error) {
+	var found []Descriptor
+	for _, d := range descs {
+		if want == "" || slices.Contains(names(d), want) {
+			found = append(found, d)
+		}
+	}
+
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+		if want == "" {
+			return Descriptor{}, errors.New("no manifests")
+		}
+		return Descriptor{}, fmt.Errorf("no manifest matches %q", want)
+	}
+
+	var options []string
+	for _, d := range found {
+		options = append(options, names(d)[0])
+	}
+	return Descriptor{}, fmt.Errorf("%d manifests match %q, pick one of: %s", len(found), want, strings.Join(options, ", "))
+}
+
+// dockerManifest is an entry in the manifest.json written by `docker save`.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func loadDocker(fsys *tarfs.FS, o *options) (*Image, error) {
+	var dms []dockerManifest
+	if err := readJSON(fsys, "manifest.json", &dms); err != nil {
+		return nil, err
+	}
+
+	var found []dockerManifest
+	for _, dm := range dms {
+		if o.ref == "" || slices.Contains(dm.RepoTags, o.ref) {
+			found = append(found, dm)
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("manifest.json: %d images match %q", len(found), o.ref)
+	}
+	dm := found[0]
+
+	m := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerSchema,
+	}
+	for range dm.Layers {
+		m.Layers = append(m.Layers, Descriptor{MediaType: "application/vnd.docker.image.rootfs.diff.tar"})
+	}
+
+	return build(fsys, fsys.OpenArchive, m, dm.Config, dm.Layers)
+}
+
+// build reads the config and opens every layer, given their paths within fsys.
+func build(fsys fs.FS, open func(string) (*tarfs.FS, error), m *Manifest, config string, layers []string) (*Image, error) {
+	img := &Image{
+		Manifest: m,
+		Config:   &Config{},
+	}
+
+	b, err := fs.ReadFile(fsys, config)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if err := json.Unmarshal(b, img.Config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", config, err)
+	}
+	img.RawConfig = b
+
+	for i, p := range layers {
+		lfs, err := open(p)
+		if err != nil {
+			return nil, fmt.Errorf("opening layer %d: %w", i, err)
+		}
+
+		img.Layers = append(img.Layers, &Layer{
+			Descriptor: m.Layers[i],
+			FS:         lfs,
+		})
+	}
+
+	return img, nil
+}
+
+func blobPath(digest string) (string, error) {
+	alg, hex, ok := strings.Cut(digest, ":")
+	if !ok || alg == "" || hex == "" || strings.ContainsAny(digest, "/\\") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return path.Join("blobs", alg, hex), nil
+}
+
+func readJSON(fsys fs.FS, name string, v any) error {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// entry is a tar entry for a test. A trailing slash makes a directory, and "->" makes a symlink.
+type entry struct {
+	name, content string
+}
+
+func makeTar(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(e.content))}
+		if strings.HasSuffix(e.name, "/") {
+			hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeDir, Mode: 0o755}
+		} else if target, ok := strings.CutPrefix(e.content, "->"); ok {
+			hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0o777}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gz(b []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func digest(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+var (
+	base = []entry{
+		{"etc/", ""},
+		{"etc/os-release", "ID=test"},
+		{"etc/passwd", "root:x:0:0"},
+		{"var/", ""},
+		{"var/cache/", ""},
+		{"var/cache/junk", "junk"},
+		{"bin/", ""},
+		{"bin/sh", "#!"},
+	}
+	top = []entry{
+		{"etc/.wh.passwd", ""},
+		{"var/cache/", ""},
+		{"var/cache/.wh..wh..opq", ""},
+		{"var/cache/fresh", "fresh"},
+		{"usr/", ""},
+		{"usr/bin", "->../bin"},
+	}
+)
+
+func checkImage(t *testing.T, img *Image) {
+	t.Helper()
+
+	if got, want := len(img.Layers), 2; got != want {
+		t.Fatalf("len(Layers): got %d, want %d", got, want)
+	}
+	if got, want := img.Config.Config.Cmd, []string{"/bin/sh"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Config.Cmd: got %v, want %v", got, want)
+	}
+
+	fsys := img.FS()
+	if err := fstest.TestFS(fsys, "etc/os-release", "var/cache/fresh", "bin/sh", "usr/bin"); err != nil {
+		t.Error(err)
+	}
+
+	for name, want := range map[string]string{
+		"etc/os-release":  "ID=test",
+		"var/cache/fresh": "fresh",
+		"usr/bin/sh":      "#!",
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("ReadFile(%q): got %q, want %q", name, got, want)
+		}
+	}
+
+	for _, name := range []string{"etc/passwd", "var/cache/junk", "etc/.wh.passwd", "var/cache/.wh..wh..opq"} {
+		if _, err := fsys.Stat(name); err == nil {
+			t.Errorf("Stat(%q): should be whited out", name)
+		}
+	}
+}
+
+func TestDockerSave(t *testing.T) {
+	config := mustJSON(t, map[string]any{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]any{"Cmd": []string{"/bin/sh"}},
+	})
+
+	// Older versions of docker symlink identical layers, so include one of those too.
+	b := makeTar(t,
+		entry{"manifest.json", mustJSON(t, []map[string]any{{
+			"Config":   "abc.json",
+			"RepoTags": []string{"test:latest"},
+			"Layers":   []string{"111/layer.tar", "222/layer.tar"},
+		}})},
+		entry{"abc.json", config},
+		entry{"111/", ""},
+		entry{"111/layer.tar", string(makeTar(t, base...))},
+		entry{"333/", ""},
+		entry{"333/layer.tar", string(gz(makeTar(t, top...)))},
+		entry{"222/", ""},
+		entry{"222/layer.tar", "->../333/layer.tar"},
+	)
+
+	fsys, err := tarfs.New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Load(fsys, WithRef("test:latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, img)
+
+	if _, err := Load(fsys, WithRef("test:missing")); err == nil {
+		t.Errorf("Load(test:missing): want error")
+	}
+}
+
+// ociLayout returns the files for an OCI image layout with a multi-platform index tagged "latest".
+func ociLayout(t *testing.T) []entry {
+	var entries []entry
+	blob := func(content string) Descriptor {
+		d := Descriptor{Digest: digest([]byte(content)), Size: int64(len(content))}
+		entries = append(entries, entry{"blobs/sha256/" + strings.TrimPrefix(d.Digest, "sha256:"), content})
+		return d
+	}
+
+	manifest := func(arch string) Descriptor {
+		config := blob(mustJSON(t, map[string]any{
+			"architecture": arch,
+			"os":           "linux",
+			"config":       map[string]any{"Cmd": []string{"/bin/sh"}},
+		}))
+		config.MediaType = "application/vnd.oci.image.config.v1+json"
+
+		lower, upper := blob(string(gz(makeTar(t, base...)))), blob(string(makeTar(t, top...)))
+		m := blob(mustJSON(t, Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeManifest,
+			Config:        config,
+			Layers:        []Descriptor{lower, upper},
+		}))
+		m.MediaType = MediaTypeManifest
+		m.Platform = &Platform{OS: "linux", Architecture: arch}
+		return m
+	}
+
+	amd64, arm64 := manifest("amd64"), manifest("arm64")
+	attestation := blob(mustJSON(t, Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest}))
+	attestation.Platform = &Platform{OS: "unknown", Architecture: "unknown"}
+
+	idx := blob(mustJSON(t, Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeIndex,
+		Manifests:     []Descriptor{amd64, arm64, attestation},
+	}))
+	idx.MediaType = MediaTypeIndex
+	idx.Annotations = map[string]string{AnnotationRefName: "latest"}
+
+	entries = append(entries,
+		entry{"oci-layout", `{"imageLayoutVersion": "1.0.0"}`},
+		entry{"index.json", mustJSON(t, Index{SchemaVersion: 2, Manifests: []Descriptor{idx}})},
+	)
+
+	return entries
+}
+
+func TestOCIArchive(t *testing.T) {
+	b := makeTar(t, ociLayout(t)...)
+	fsys, err := tarfs.New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(fsys); err == nil || !strings.Contains(err.Error(), "linux/arm64") {
+		t.Errorf("Load without a platform: want error listing platforms, got %v", err)
+	}
+
+	img, err := Load(fsys, WithRef("latest"), WithPlatform("linux/arm64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImage(t, img)
+
+	if got, want := img.Config.Architecture, "arm64"; got != want {
+		t.Errorf("Architecture: got %q, want %q", got, want)
+	}
+	if got, want := img.Layers[1].Digest, img.Manifest.Layers[1].Digest; got != want {
+		t.Errorf("Layers[1].Digest: got %q, want %q", got, want)
+	}
+}
+
+func TestResolvePlatform(t *testing.T) {
+	amd64 := mustJSON(t, Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest})
+	other := mustJSON(t, Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest, Annotations: map[string]string{"no": "platform"}})
+
+	// Index entries don't have to have a platform.
+	index := mustJSON(t, Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeIndex,
+		Manifests: []Descriptor{
+			{MediaType: MediaTypeManifest, Digest: digest([]byte(amd64)), Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+			{MediaType: MediaTypeManifest, Digest: digest([]byte(other))},
+		},
+	})
+
+	blobs := map[string]string{}
+	for _, b := range []string{amd64, other, index} {
+		blobs[digest([]byte(b))] = b
+	}
+	fetch := func(d Descriptor) ([]byte, error) {
+		return []byte(blobs[d.Digest]), nil
+	}
+
+	desc := Descriptor{MediaType: MediaTypeIndex, Digest: digest([]byte(index))}
+
+	m, err := Resolve(desc, "linux/amd64", fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Annotations != nil {
+		t.Errorf("Resolve(linux/amd64): got the manifest without a platform")
+	}
+
+	if _, err := Resolve(desc, "", fetch); err == nil || !strings.Contains(err.Error(), "2 manifests match") {
+		t.Errorf("Resolve(): want an ambiguous match, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package plan maps files in a [tarfs.FS] to the compressed bytes that back them in a [gsip.Reader].
+//
+// This is useful for prefetching or caching exactly the parts of a remote tar.gz that some files need.
+package plan
+
+import (
+	"fmt"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Spanner is implemented by *gsip.Index and *gsip.Reader.
+//
+// A *gsip.Index may return open ended compressed ranges for the last span in the stream,
+// whereas a *gsip.Reader knows the size of the blob.
+type Spanner interface {
+	Span(off, length int64) (gsip.Span, error)
+}
+
+var (
+	_ Spanner = (*gsip.Index)(nil)
+	_ Spanner = (*gsip.Reader)(nil)
+)
+
+// File returns the span of the gzip stream needed to read the data of e.
+func File(s Spanner, e *tarfs.Entry) (gsip.Span, error) {
+	span, err := s.Span(e.Offset, e.PhysicalSize())
+	if err != nil {
+		return span, fmt.Errorf("planning %s: %w", e.Filename, err)
+	}
+
+	return span, nil
+}
+
+// Files returns the spans of the gzip stream needed to read the data of every entry,
+// sorted by compressed offset and merged where they overlap.
+//
+// Entries without any data (directories, links, empty files) are skipped.
+func Files(s Spanner, entries ...*tarfs.Entry) ([]gsip.Span, error) {
+	spans := make([]gsip.Span, 0, len(entries))
+	for _, e := range entries {
+		if e.PhysicalSize() == 0 {
+			continue
+		}
+
+		span, err := File(s, e)
+		if err != nil {
+			return nil, err
+		}
+
+		spans = append(spans, span)
+	}
+
+	return gsip.Merge(spans), nil
+}
+
+// Cost sums up the compressed bytes to fetch and the decompressed bytes to produce for spans.
+// Open ended compressed ranges are not counted.
+func Cost(spans []gsip.Span) (compressed, decompressed int64) {
+	for _, span := range spans {
+		if span.Compressed.Length > 0 {
+			compressed += span.Compressed.Length
+		}
+		decompressed += span.Decompressed.Length
+	}
+
+	return compressed, decompressed
+}
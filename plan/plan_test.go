@@ -0,0 +1,153 @@
+package plan
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// onlyRange is an io.ReaderAt that zeros out everything outside of a given range,
+// so reads only succeed if that range has all the bytes they need.
+type onlyRange struct {
+	data []byte
+	r    gsip.Range
+}
+
+func (o *onlyRange) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(o.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, o.data[off:])
+	for i := range n {
+		if pos := off + int64(i); pos < o.r.Offset || pos >= o.r.End() {
+			p[i] = 0
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func words(rng *rand.Rand, n int) []byte {
+	vocab := []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do"}
+
+	buf := &bytes.Buffer{}
+	for buf.Len() < n {
+		fmt.Fprintf(buf, "%s %d ", vocab[rng.IntN(len(vocab))], rng.IntN(1000))
+	}
+	return buf.Bytes()[:n]
+}
+
+func TestFiles(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	contents := map[string][]byte{}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for i := range 4 {
+		name := fmt.Sprintf("file%d", i)
+		contents[name] = words(rng, 3<<20)
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(contents[name])),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(contents[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	blob := buf.Bytes()
+	zr, err := gsip.NewReader(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := tarfs.New(zr, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := &bytes.Buffer{}
+	if err := zr.Encode(index); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zr.Index().Checkpoints) < 3 {
+		t.Fatalf("expected several checkpoints, got %d", len(zr.Index().Checkpoints))
+	}
+
+	var entries []*tarfs.Entry
+	for name, want := range contents {
+		e, err := fsys.Entry(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+
+		span, err := File(zr, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if span.Discard != e.Offset-span.Decompressed.Offset {
+			t.Errorf("%s: Discard = %d, want %d", name, span.Discard, e.Offset-span.Decompressed.Offset)
+		}
+		if span.Decompressed.End() != e.Offset+e.Size() {
+			t.Errorf("%s: Decompressed ends at %d, want %d", name, span.Decompressed.End(), e.Offset+e.Size())
+		}
+
+		// Reading the file through only the planned compressed bytes should work.
+		ra := &onlyRange{data: blob, r: span.Compressed}
+		restored, err := gsip.Decode(ra, int64(len(blob)), bytes.NewReader(index.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]byte, e.Size())
+		if _, err := restored.ReadAt(got, e.Offset); err != nil && err != io.EOF {
+			t.Fatalf("%s: ReadAt: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: planned range %+v was not enough to read the file", name, span.Compressed)
+		}
+	}
+
+	spans, err := Files(zr.Index(), entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file is adjacent to the next, so this should all merge together.
+	if len(spans) != 1 {
+		t.Fatalf("Files(): want 1 merged span, got %d: %+v", len(spans), spans)
+	}
+	if spans[0].Compressed.Length != -1 {
+		t.Errorf("Files() with an Index: want open ended range, got %+v", spans[0].Compressed)
+	}
+
+	compressed, decompressed := Cost(spans)
+	if compressed != 0 || decompressed < 4*(3<<20) {
+		t.Errorf("Cost(): got %d, %d", compressed, decompressed)
+	}
+}
@@ -0,0 +1,181 @@
+// Package prefetch warms up a [gsip.Reader] using a [tarfs.Profile] recorded from a previous run.
+//
+// The spans of the gzip stream that back each file in the profile are fetched with a single ReadAt
+// each (a single range request when using ranger) in order of compressed offset, decompressed in the
+// background, and kept in memory so that reads of those files don't have to wait on the network.
+package prefetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Reader is an io.ReaderAt over the uncompressed stream that serves prefetched bytes from memory
+// and falls back to the underlying gsip.Reader for everything else.
+type Reader struct {
+	zr       *gsip.Reader
+	maxBytes int64
+
+	mu sync.Mutex
+	// sorted by offset
+	chunks []chunk
+	// total size of chunks
+	size int64
+}
+
+type chunk struct {
+	off  int64
+	data []byte
+}
+
+// DefaultMaxBytes is how much [Reader] keeps in memory unless you pass [WithMaxBytes].
+const DefaultMaxBytes = 256 << 20
+
+// Option configures a [Reader].
+type Option func(*Reader)
+
+// WithMaxBytes caps how many decompressed bytes a Reader keeps in memory.
+// Prefetching stops once the next span wouldn't fit.
+func WithMaxBytes(n int64) Option {
+	return func(r *Reader) {
+		r.maxBytes = n
+	}
+}
+
+func NewReader(zr *gsip.Reader, opts ...Option) *Reader {
+	r := &Reader{
+		zr:       zr,
+		maxBytes: DefaultMaxBytes,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.cached(p, off) {
+		return len(p), nil
+	}
+
+	return r.zr.ReadAt(p, off)
+}
+
+// cached copies into p if a single prefetched chunk covers all of it.
+func (r *Reader) cached(p []byte, off int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The last chunk that starts at or before off.
+	i := sort.Search(len(r.chunks), func(i int) bool {
+		return r.chunks[i].off > off
+	}) - 1
+	if i < 0 {
+		return false
+	}
+
+	c := r.chunks[i]
+	if off+int64(len(p)) > c.off+int64(len(c.data)) {
+		return false
+	}
+
+	copy(p, c.data[off-c.off:])
+	return true
+}
+
+// add caches c, unless that would put us over maxBytes.
+func (r *Reader) add(c chunk) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size+int64(len(c.data)) > r.maxBytes {
+		return false
+	}
+	r.size += int64(len(c.data))
+
+	i := sort.Search(len(r.chunks), func(i int) bool {
+		return r.chunks[i].off > c.off
+	})
+	r.chunks = append(r.chunks, chunk{})
+	copy(r.chunks[i+1:], r.chunks[i:])
+	r.chunks[i] = c
+	return true
+}
+
+// Plan returns the spans needed to read every file in p, sorted by compressed offset.
+func (r *Reader) Plan(p *tarfs.Profile) ([]gsip.Span, error) {
+	spans := make([]gsip.Span, 0, len(p.Accesses))
+	for _, a := range p.Accesses {
+		span, err := r.zr.Span(a.Offset, a.Length)
+		if err != nil {
+			return nil, fmt.Errorf("planning %s: %w", a.Name, err)
+		}
+		spans = append(spans, span)
+	}
+
+	return gsip.Merge(spans), nil
+}
+
+// Prefetch fetches and decompresses every span needed by p, blocking until it's done
+// or the cache is full (which isn't an error).
+//
+// Most callers want [Reader.Start] instead.
+func (r *Reader) Prefetch(ctx context.Context, p *tarfs.Profile) error {
+	spans, err := r.Plan(p)
+	if err != nil {
+		return err
+	}
+
+	for _, span := range spans {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := r.zr.Fetch(span)
+		if err != nil {
+			return err
+		}
+
+		if !r.add(chunk{
+			off:  span.Decompressed.Offset + span.Discard,
+			data: data,
+		}) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Start calls [Reader.Prefetch] in the background.
+// The returned channel receives its result and is then closed.
+//
+// Failing to prefetch isn't fatal, since reads fall back to the gsip.Reader.
+func (r *Reader) Start(ctx context.Context, p *tarfs.Profile) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		errc <- r.Prefetch(ctx, p)
+	}()
+
+	return errc
+}
+
+// Open restores a tarfs.FS from its TOC on top of a prefetching Reader and starts prefetching p.
+// The returned channel is the one from [Reader.Start].
+func Open(ctx context.Context, zr *gsip.Reader, toc io.Reader, p *tarfs.Profile, opts ...Option) (*tarfs.FS, <-chan error, error) {
+	r := NewReader(zr, opts...)
+
+	fsys, err := tarfs.Decode(r, toc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fsys, r.Start(ctx, p), nil
+}
@@ -0,0 +1,212 @@
+package prefetch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/ranger"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// countingTransport counts the requests that make it to the server.
+type countingTransport struct {
+	rt http.RoundTripper
+	n  atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return c.rt.RoundTrip(req)
+}
+
+func TestPrefetch(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	contents := map[string][]byte{}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for i := range 8 {
+		name := fmt.Sprintf("file%d", i)
+		b := make([]byte, 1<<20)
+		for j := range b {
+			b[j] = byte('a' + rng.IntN(4))
+		}
+		contents[name] = b
+
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(b))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blob := buf.Bytes()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob.tar.gz", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer s.Close()
+
+	// First run: index everything and record a profile.
+	zr, err := gsip.NewReader(ranger.New(context.Background(), s.URL, s.Client().Transport), int64(len(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := tarfs.New(zr, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, toc := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := zr.Encode(index); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys.Record()
+	startup := []string{"file6", "file1", "file2"}
+	for _, name := range startup {
+		if _, err := fs.ReadFile(fsys, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.Stat(fsys, "file3"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := &bytes.Buffer{}
+	if err := fsys.Profile().Encode(recorded); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := tarfs.DecodeProfile(recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(profile.Accesses) != len(startup) {
+		t.Fatalf("want %d accesses, got %+v", len(startup), profile.Accesses)
+	}
+	for i, a := range profile.Accesses {
+		if a.Name != startup[i] {
+			t.Errorf("access %d: want %q, got %q", i, startup[i], a.Name)
+		}
+	}
+
+	// Second run: prefetch from the profile, then make sure reads don't hit the network.
+	ct := &countingTransport{rt: s.Client().Transport}
+	zr, err = gsip.Decode(ranger.New(context.Background(), s.URL, ct), int64(len(blob)), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(zr)
+	spans, err := r.Plan(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(spans); i++ {
+		if spans[i].Compressed.Offset < spans[i-1].Compressed.Offset {
+			t.Errorf("spans not ordered by compressed offset: %+v", spans)
+		}
+	}
+
+	fsys, err = tarfs.Decode(r, toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-r.Start(context.Background(), profile); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ct.n.Load(); got != int64(len(spans)) {
+		t.Errorf("prefetching: want %d requests, got %d", len(spans), got)
+	}
+
+	before := ct.n.Load()
+	for _, name := range startup {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, contents[name]) {
+			t.Errorf("ReadFile(%q): mismatched contents", name)
+		}
+	}
+	if after := ct.n.Load(); after != before {
+		t.Errorf("reading prefetched files made %d requests", after-before)
+	}
+
+	// Files that weren't prefetched still work.
+	if b, err := fs.ReadFile(fsys, "file4"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, contents["file4"]) {
+		t.Errorf("ReadFile(file4): mismatched contents")
+	}
+
+	// With a tiny cache, Open's prefetching stops without an error and reads still work.
+	toc.Reset()
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+	small, errc, err := Open(context.Background(), zr, toc, profile, WithMaxBytes(1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fs.ReadFile(small, "file6"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, contents["file6"]) {
+		t.Errorf("ReadFile(file6): mismatched contents")
+	}
+
+	// Prefetching over a ranged index has to fetch the windows it starts from.
+	// Just file6 is late enough in the stream that its span doesn't start at the (windowless) first checkpoint.
+	last := &tarfs.Profile{Accesses: profile.Accesses[:1]}
+	ranged := &bytes.Buffer{}
+	if err := gsip.EncodeRanged(ranged, zr.Index(), int64(len(blob))); err != nil {
+		t.Fatal(err)
+	}
+	rzr, err := gsip.DecodeRanged(bytes.NewReader(blob), bytes.NewReader(ranged.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc.Reset()
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+	rfs, errc, err := Open(context.Background(), rzr, toc, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fs.ReadFile(rfs, "file6"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, contents["file6"]) {
+		t.Errorf("ReadFile(file6) over a ranged index: mismatched contents")
+	}
+}
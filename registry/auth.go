@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// transport answers auth challenges from a single registry host.
+//
+// Credentials are only ever sent to that host, so when a blob request gets redirected to storage
+// (which has its own auth baked into the URL), nothing leaks.
+type transport struct {
+	base http.RoundTripper
+	host string
+
+	username, password string
+
+	mu   sync.Mutex
+	auth string // Authorization header for host, once we have one
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	auth := t.auth
+	t.mu.Unlock()
+
+	res, err := t.base.RoundTrip(withAuth(req, auth))
+	if err != nil || res.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return res, err
+	}
+
+	challenge := res.Header.Get("WWW-Authenticate")
+	res.Body.Close()
+
+	auth, err = t.answer(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.auth = auth
+	t.mu.Unlock()
+
+	return t.base.RoundTrip(withAuth(req, auth))
+}
+
+func withAuth(req *http.Request, auth string) *http.Request {
+	if auth == "" {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", auth)
+	return req
+}
+
+// answer returns an Authorization header for challenge.
+func (t *transport) answer(req *http.Request, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if t.username == "" {
+			return "", fmt.Errorf("%s requires basic auth", t.host)
+		}
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(t.username, t.password)
+		return r.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := t.token(req, params)
+		if err != nil {
+			return "", fmt.Errorf("fetching token from %s: %w", params["realm"], err)
+		}
+		return "Bearer " + token, nil
+	}
+
+	return "", fmt.Errorf("%s: unsupported auth challenge %q", t.host, challenge)
+}
+
+// token implements the docker token flow: https://distribution.github.io/distribution/spec/auth/token/
+func (t *transport) token(req *http.Request, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid realm %q", params["realm"])
+	}
+
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		q.Set("scope", scope)
+	} else if repo := repository(req.URL.Path); repo != "" {
+		q.Set("scope", "repository:"+repo+":pull")
+	}
+	realm.RawQuery = q.Encode()
+
+	treq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if t.username != "" {
+		treq.SetBasicAuth(t.username, t.password)
+	}
+
+	res, err := t.base.RoundTrip(treq)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return "", fmt.Errorf("status %d: %s", res.StatusCode, b)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("no token in response")
+}
+
+// parseChallenge parses a WWW-Authenticate header like `Bearer realm="...",service="..."`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+
+		key, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+
+		// Values may be quoted and quoted values may contain commas (e.g. a scope with multiple actions).
+		if strings.HasPrefix(after, `"`) {
+			end := strings.Index(after[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = after[1:end+1], after[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(after, ",")
+		}
+
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+
+	return scheme, params
+}
+
+// repository extracts the repository from a /v2/<repo>/{manifests,blobs}/<ref> path.
+func repository(p string) string {
+	p, ok := strings.CutPrefix(p, "/v2/")
+	if !ok {
+		return ""
+	}
+
+	for _, sep := range []string{"/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(p, sep); i >= 0 {
+			return p[:i]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultRegistry is used for references without a registry, like "alpine:3".
+const DefaultRegistry = "index.docker.io"
+
+// Ref is a parsed image reference, like "cgr.dev/chainguard/static:latest".
+type Ref struct {
+	Registry   string
+	Repository string
+
+	// Exactly one of Tag or Digest is set.
+	Tag    string
+	Digest string
+}
+
+// ParseRef parses s as registry/repository[:tag][@digest], filling in the same defaults as docker:
+// the registry is [DefaultRegistry], single-component repositories are in "library/", and the tag is "latest".
+// If s has a digest, the tag is ignored.
+func ParseRef(s string) (Ref, error) {
+	ref := Ref{}
+
+	rest, digest, ok := strings.Cut(s, "@")
+	if ok {
+		if alg, hex, ok := strings.Cut(digest, ":"); !ok || alg == "" || hex == "" {
+			return Ref{}, fmt.Errorf("parsing %q: invalid digest %q", s, digest)
+		}
+		ref.Digest = digest
+	}
+
+	// A colon after the last slash is a tag, not a port.
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+		if ref.Tag == "" {
+			return Ref{}, fmt.Errorf("parsing %q: empty tag", s)
+		}
+	}
+	if ref.Digest != "" {
+		ref.Tag = ""
+	} else if ref.Tag == "" {
+		ref.Tag = "latest"
+	}
+
+	ref.Registry, ref.Repository = DefaultRegistry, rest
+	if first, repo, ok := strings.Cut(rest, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, repo
+	}
+
+	if ref.Registry == "docker.io" {
+		ref.Registry = DefaultRegistry
+	}
+	if ref.Registry == DefaultRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if ref.Repository == "" || ref.Repository != strings.ToLower(ref.Repository) {
+		return Ref{}, fmt.Errorf("parsing %q: invalid repository %q", s, ref.Repository)
+	}
+
+	return ref, nil
+}
+
+// Identifier is the tag or digest to ask the registry for.
+func (r Ref) Identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r Ref) String() string {
+	if r.Digest != "" {
+		return r.Registry + "/" + r.Repository + "@" + r.Digest
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// scheme guesses whether the registry speaks plain http, the same way docker does for local registries.
+func (r Ref) scheme() string {
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".local") {
+		return "http"
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http"
+	}
+
+	return "https"
+}
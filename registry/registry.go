@@ -0,0 +1,271 @@
+// Package registry pulls images from an OCI (or docker) registry without downloading their layers.
+//
+// Each layer is a [ranger.Reader] doing range requests against the blob (following redirects to storage),
+// wrapped in a [gsip.Reader] and indexed by [tarfs.FS], so only the bytes you actually read get fetched.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/oci"
+	"github.com/jonjohnsonjr/targz/ranger"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Media types we accept for manifests, most preferred first.
+var acceptable = []string{
+	oci.MediaTypeIndex,
+	oci.MediaTypeManifest,
+	oci.MediaTypeDockerList,
+	oci.MediaTypeDockerSchema,
+}
+
+// Manifests and configs are small, so refuse anything that isn't.
+const maxManifestSize = 4 << 20
+
+// Option configures a [Client].
+type Option func(*Client)
+
+// Client fetches images from registries.
+type Client struct {
+	rt       http.RoundTripper
+	username string
+	password string
+	platform string
+	layer    []tarfs.Option
+}
+
+// WithTransport sets the http.RoundTripper used for every request. The default is [http.DefaultTransport].
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.rt = rt
+	}
+}
+
+// WithBasicAuth sets the credentials used for basic auth and for fetching bearer tokens.
+// Without these, we try to pull anonymously.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username, c.password = username, password
+	}
+}
+
+// WithPlatform picks the "os/arch" or "os/arch/variant" image from a multi-platform index.
+func WithPlatform(platform string) Option {
+	return func(c *Client) {
+		c.platform = platform
+	}
+}
+
+// WithLayerOptions passes opts to [tarfs.New] for each layer.
+func WithLayerOptions(opts ...tarfs.Option) Option {
+	return func(c *Client) {
+		c.layer = append(c.layer, opts...)
+	}
+}
+
+// New returns a Client that pulls anonymously unless given [WithBasicAuth].
+func New(opts ...Option) *Client {
+	c := &Client{
+		rt: http.DefaultTransport,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// repo talks to a single repository.
+type repo struct {
+	ref    Ref
+	client *http.Client
+	rt     http.RoundTripper
+}
+
+func (c *Client) repo(ref Ref) *repo {
+	rt := &transport{
+		base:     c.rt,
+		host:     ref.Registry,
+		username: c.username,
+		password: c.password,
+	}
+
+	return &repo{
+		ref:    ref,
+		rt:     rt,
+		client: &http.Client{Transport: rt},
+	}
+}
+
+func (r *repo) url(kind, identifier string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", r.ref.scheme(), r.ref.Registry, r.ref.Repository, kind, identifier)
+}
+
+// Image resolves s (see [ParseRef]) to an image and indexes every layer.
+//
+// Indexing reads each layer once from start to finish, but afterwards reads only fetch the spans they need.
+// The layers keep using ctx for their range requests.
+func (c *Client) Image(ctx context.Context, s string) (*oci.Image, error) {
+	ref, err := ParseRef(s)
+	if err != nil {
+		return nil, err
+	}
+
+	r := c.repo(ref)
+
+	// Fetch the tag (or digest) first so we know the digest of whatever it points to.
+	b, mediaType, err := r.manifest(ctx, ref.Identifier())
+	if err != nil {
+		return nil, err
+	}
+
+	desc := oci.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest(b),
+		Size:      int64(len(b)),
+	}
+	if ref.Digest != "" && desc.Digest != ref.Digest {
+		return nil, fmt.Errorf("%s: got manifest with digest %s", ref, desc.Digest)
+	}
+
+	m, err := oci.Resolve(desc, c.platform, func(d oci.Descriptor) ([]byte, error) {
+		if d.Digest == desc.Digest {
+			return b, nil
+		}
+
+		b, _, err := r.manifest(ctx, d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if got := digest(b); got != d.Digest {
+			return nil, fmt.Errorf("manifest %s has digest %s", d.Digest, got)
+		}
+		return b, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+
+	img := &oci.Image{
+		Manifest: m,
+		Config:   &oci.Config{},
+	}
+
+	if img.RawConfig, err = r.blob(ctx, m.Config); err != nil {
+		return nil, fmt.Errorf("fetching config: %w", err)
+	}
+	if err := json.Unmarshal(img.RawConfig, img.Config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", m.Config.Digest, err)
+	}
+
+	for i, l := range m.Layers {
+		fsys, err := r.layer(ctx, l, c.layer)
+		if err != nil {
+			return nil, fmt.Errorf("opening layer %d (%s): %w", i, l.Digest, err)
+		}
+
+		img.Layers = append(img.Layers, &oci.Layer{
+			Descriptor: l,
+			FS:         fsys,
+		})
+	}
+
+	return img, nil
+}
+
+// manifest fetches the manifest for identifier and returns it with its media type.
+func (r *repo) manifest(ctx context.Context, identifier string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url("manifests", identifier), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptable, ","))
+
+	b, res, err := r.get(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, res.Header.Get("Content-Type"), nil
+}
+
+// blob fetches a small blob and checks its digest.
+func (r *repo) blob(ctx context.Context, desc oci.Descriptor) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url("blobs", desc.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b, _, err := r.get(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if got := digest(b); got != desc.Digest {
+		return nil, fmt.Errorf("blob %s has digest %s", desc.Digest, got)
+	}
+
+	return b, nil
+}
+
+func (r *repo) get(req *http.Request) ([]byte, *http.Response, error) {
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return nil, nil, fmt.Errorf("GET %s: status %d: %s", req.URL, res.StatusCode, bytes.TrimSpace(b))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) > maxManifestSize {
+		return nil, nil, fmt.Errorf("GET %s: response is over %d bytes", req.URL, maxManifestSize)
+	}
+
+	return b, res, nil
+}
+
+// layer indexes the layer blob at desc through range requests.
+func (r *repo) layer(ctx context.Context, desc oci.Descriptor, opts []tarfs.Option) (*tarfs.FS, error) {
+	var (
+		ra   io.ReaderAt = ranger.New(ctx, r.url("blobs", desc.Digest), r.rt)
+		size             = desc.Size
+	)
+
+	magic := make([]byte, 2)
+	if _, err := ra.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gsip.NewReader(ra, size)
+		if err != nil {
+			return nil, err
+		}
+
+		// We don't know the uncompressed size.
+		ra, size = zr, -1
+	}
+
+	return tarfs.New(ra, size, opts...)
+}
+
+func digest(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
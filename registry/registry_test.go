@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonjohnsonjr/targz/oci"
+)
+
+func TestParseRef(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"alpine", "index.docker.io/library/alpine:latest"},
+		{"docker.io/alpine:3", "index.docker.io/library/alpine:3"},
+		{"cgr.dev/chainguard/static", "cgr.dev/chainguard/static:latest"},
+		{"localhost:5000/foo/bar:v1", "localhost:5000/foo/bar:v1"},
+		{"localhost/foo", "localhost/foo:latest"},
+		{"gcr.io/foo:tag@sha256:abcd", "gcr.io/foo@sha256:abcd"},
+		{"127.0.0.1:1234/foo@sha256:abcd", "127.0.0.1:1234/foo@sha256:abcd"},
+	} {
+		ref, err := ParseRef(tc.in)
+		if err != nil {
+			t.Errorf("ParseRef(%q): %v", tc.in, err)
+			continue
+		}
+		if got := ref.String(); got != tc.want {
+			t.Errorf("ParseRef(%q): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{"foo:", "foo@sha256", "Foo/Bar", "example.com/"} {
+		if _, err := ParseRef(in); err == nil {
+			t.Errorf("ParseRef(%q): want error", in)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo/bar:pull,push"`)
+	if scheme != "Bearer" {
+		t.Errorf("scheme: got %q", scheme)
+	}
+	for k, want := range map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:foo/bar:pull,push",
+	} {
+		if got := params[k]; got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+}
+
+// fakeRegistry serves a single repository that requires a bearer token,
+// redirecting blob requests to a separate storage server.
+type fakeRegistry struct {
+	t *testing.T
+
+	registry, storage *httptest.Server
+
+	manifests map[string][]byte // by tag and digest
+	blobs     map[string][]byte
+
+	tokens   atomic.Int64
+	rangeGet atomic.Int64
+}
+
+const token = "let-me-in"
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{
+		t:         t,
+		manifests: map[string][]byte{},
+		blobs:     map[string][]byte{},
+	}
+
+	f.storage = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("storage got credentials for %s", r.URL)
+		}
+		b, ok := f.blobs[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			f.rangeGet.Add(1)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	}))
+	t.Cleanup(f.storage.Close)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Query().Get("scope"), "repository:test/image:pull"; got != want {
+			t.Errorf("token scope: got %q, want %q", got, want)
+		}
+		f.tokens.Add(1)
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+	mux.HandleFunc("/v2/test/image/manifests/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		if !f.authorized(w, r) {
+			return
+		}
+		b, ok := f.manifests[r.PathValue("ref")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var probe struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(b, &probe)
+		w.Header().Set("Content-Type", probe.MediaType)
+		w.Write(b)
+	})
+	mux.HandleFunc("/v2/test/image/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+		if !f.authorized(w, r) {
+			return
+		}
+		http.Redirect(w, r, f.storage.URL+"/"+r.PathValue("digest"), http.StatusTemporaryRedirect)
+	})
+	f.registry = httptest.NewServer(mux)
+	t.Cleanup(f.registry.Close)
+
+	return f
+}
+
+func (f *fakeRegistry) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") == "Bearer "+token {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="fake"`, f.registry.URL+"/token"))
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func (f *fakeRegistry) blob(b []byte) oci.Descriptor {
+	d := oci.Descriptor{Digest: digest(b), Size: int64(len(b))}
+	f.blobs[d.Digest] = b
+	return d
+}
+
+func (f *fakeRegistry) manifest(tag string, v any) oci.Descriptor {
+	b, err := json.Marshal(v)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	d := oci.Descriptor{Digest: digest(b), Size: int64(len(b))}
+	f.manifests[d.Digest] = b
+	if tag != "" {
+		f.manifests[tag] = b
+	}
+	return d
+}
+
+func layer(t *testing.T, compress bool, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !compress {
+		return buf.Bytes()
+	}
+
+	zbuf := &bytes.Buffer{}
+	zw := gzip.NewWriter(zbuf)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return zbuf.Bytes()
+}
+
+func TestImage(t *testing.T) {
+	f := newFakeRegistry(t)
+
+	config := f.blob([]byte(`{"architecture":"arm64","os":"linux","config":{"Cmd":["/hello"]}}`))
+	lower := f.blob(layer(t, true, map[string]string{"hello": "world", "etc/os-release": "ID=test"}))
+	upper := f.blob(layer(t, false, map[string]string{"etc/.wh.os-release": "", "etc/motd": "hi"}))
+
+	m := f.manifest("", oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeManifest,
+		Config:        config,
+		Layers:        []oci.Descriptor{lower, upper},
+	})
+	m.MediaType = oci.MediaTypeManifest
+	m.Platform = &oci.Platform{OS: "linux", Architecture: "arm64"}
+	f.manifest("latest", oci.Index{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeIndex,
+		Manifests:     []oci.Descriptor{m},
+	})
+
+	ref := strings.TrimPrefix(f.registry.URL, "http://") + "/test/image"
+	c := New(WithPlatform("linux/arm64"))
+
+	img, err := c.Image(context.Background(), ref+":latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := img.Config.Config.Cmd, []string{"/hello"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Cmd: got %v, want %v", got, want)
+	}
+	if got := f.tokens.Load(); got != 1 {
+		t.Errorf("fetched %d tokens, want 1", got)
+	}
+	if f.rangeGet.Load() == 0 {
+		t.Errorf("storage never got a range request")
+	}
+
+	fsys := img.FS()
+	for name, want := range map[string]string{"hello": "world", "etc/motd": "hi"} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("ReadFile(%q): got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := fsys.Stat("etc/os-release"); err == nil {
+		t.Errorf("etc/os-release should be whited out")
+	}
+
+	// Pulling by digest skips the index, and a wrong digest is an error.
+	if _, err := c.Image(context.Background(), ref+"@"+m.Digest); err != nil {
+		t.Errorf("by digest: %v", err)
+	}
+	f.manifests["sha256:0000"] = f.manifests[m.Digest]
+	if _, err := c.Image(context.Background(), ref+"@sha256:0000"); err == nil {
+		t.Errorf("mismatched digest: want error")
+	}
+
+	if _, err := c.Image(context.Background(), ref+":missing"); err == nil {
+		t.Errorf("missing tag: want error")
+	}
+}
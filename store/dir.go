@@ -0,0 +1,55 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Dir is an [IndexStore] in a local directory, laid out as "<dir>/<algorithm>/<hex>.<kind>".
+type Dir string
+
+var _ IndexStore = Dir("")
+
+func (d Dir) Get(digest string, kind Kind) ([]byte, error) {
+	k, err := key(digest, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(string(d), filepath.FromSlash(k)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Put writes to a temp file and renames it, so a concurrent Get never sees half an index.
+func (d Dir) Put(digest string, kind Kind, index []byte) error {
+	k, err := key(digest, kind)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(string(d), filepath.FromSlash(k))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(index); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HTTP is an [IndexStore] that GETs and PUTs indexes at "<base>/<algorithm>/<hex>.<kind>",
+// which works with most object stores and a lot of static file servers.
+type HTTP struct {
+	ctx  context.Context
+	base string
+	rt   http.RoundTripper
+}
+
+var _ IndexStore = (*HTTP)(nil)
+
+// NewHTTP returns an [IndexStore] rooted at the base URL. If rt is nil, http.DefaultTransport is used.
+func NewHTTP(ctx context.Context, base string, rt http.RoundTripper) *HTTP {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return &HTTP{
+		ctx:  ctx,
+		base: strings.TrimSuffix(base, "/"),
+		rt:   rt,
+	}
+}
+
+func (h *HTTP) do(method, digest string, kind Kind, body []byte) (*http.Response, error) {
+	k, err := key(digest, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(h.ctx, method, h.base+"/"+k, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return (&http.Client{Transport: h.rt}).Do(req)
+}
+
+func (h *HTTP) Get(digest string, kind Kind) ([]byte, error) {
+	resp, err := h.do(http.MethodGet, digest, kind, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	}
+
+	return nil, fmt.Errorf("GET %s: unexpected status: %s", resp.Request.URL, resp.Status)
+}
+
+func (h *HTTP) Put(digest string, kind Kind, index []byte) error {
+	resp, err := h.do(http.MethodPut, digest, kind, index)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: unexpected status: %s", resp.Request.URL, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package store persists indexes (gsip checkpoints, bsip blocks, tarfs TOCs) so they can be shared
+// across processes and machines, keyed by the digest of the blob they describe.
+//
+// [gsip.WithIndexStore] and [tarfs.WithIndexStore] consult an [IndexStore] before indexing
+// and publish to it afterwards.
+//
+// [gsip.WithIndexStore]: https://pkg.go.dev/github.com/jonjohnsonjr/targz/gsip#WithIndexStore
+// [tarfs.WithIndexStore]: https://pkg.go.dev/github.com/jonjohnsonjr/targz/tarfs#WithIndexStore
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind is the type of index, since a single blob can have several.
+type Kind string
+
+const (
+	// Gsip is a gsip.Index, from gsip.Reader.Encode.
+	Gsip Kind = "gsip"
+
+	// TOC is a tarfs.TOC, from tarfs.FS.Encode.
+	TOC Kind = "tarfs"
+
+	// Bsip is a bsip.Index, from bsip.Reader.Encode.
+	Bsip Kind = "bsip"
+)
+
+// ErrNotFound is returned by Get when there's no index of that kind for the blob.
+var ErrNotFound = errors.New("index not found")
+
+// IndexStore gets and puts indexes by blob digest (e.g. "sha256:abc...") and kind.
+//
+// Implementations must be safe for concurrent use, and Put must never let Get see a partial index.
+type IndexStore interface {
+	Get(digest string, kind Kind) ([]byte, error)
+	Put(digest string, kind Kind, index []byte) error
+}
+
+// key returns a relative path for an index, like "sha256/abc....gsip".
+// This rejects anything that doesn't look like a digest, so it's safe to use in a path or URL.
+func key(digest string, kind Kind) (string, error) {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok || !valid(algo, "abcdefghijklmnopqrstuvwxyz0123456789") || !valid(hex, "0123456789abcdef") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	if !valid(string(kind), "abcdefghijklmnopqrstuvwxyz0123456789-") {
+		return "", fmt.Errorf("invalid kind %q", kind)
+	}
+
+	return algo + "/" + hex + "." + string(kind), nil
+}
+
+func valid(s, chars string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(chars, c) {
+			return false
+		}
+	}
+	return true
+}
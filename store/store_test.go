@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const digest = "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// server is a minimal object store.
+func server() *httptest.Server {
+	var (
+		mu      sync.Mutex
+		objects = map[string][]byte{}
+	)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(b)
+		case http.MethodPut:
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = b
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestStores(t *testing.T) {
+	s := server()
+	defer s.Close()
+
+	for name, st := range map[string]IndexStore{
+		"dir":  Dir(t.TempDir()),
+		"http": NewHTTP(context.Background(), s.URL+"/indexes/", s.Client().Transport),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := st.Get(digest, Gsip); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get before Put: want ErrNotFound, got %v", err)
+			}
+
+			if err := st.Put(digest, Gsip, []byte("checkpoints")); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Put(digest, TOC, []byte("entries")); err != nil {
+				t.Fatal(err)
+			}
+
+			for kind, want := range map[Kind]string{Gsip: "checkpoints", TOC: "entries"} {
+				got, err := st.Get(digest, kind)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("Get(%s): got %q, want %q", kind, got, want)
+				}
+			}
+
+			for _, bad := range []string{"", "sha256", "sha256:../../etc/passwd", "SHA256:abc", "sha256:ABC"} {
+				if _, err := st.Get(bad, Gsip); err == nil || errors.Is(err, ErrNotFound) {
+					t.Errorf("Get(%q): want invalid digest error, got %v", bad, err)
+				}
+				if err := st.Put(bad, Gsip, nil); err == nil {
+					t.Errorf("Put(%q): want error", bad)
+				}
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	got, err := key(digest, TOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sha256/" + strings.TrimPrefix(digest, "sha256:") + ".tarfs"; got != want {
+		t.Errorf("key: got %q, want %q", got, want)
+	}
+
+	if _, err := key(digest, "../x"); err == nil {
+		t.Error("want error for bad kind")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNoDigest is returned by [FS.Verify] when the TOC has no digest for a file.
+	ErrNoDigest = errors.New("no digest recorded")
+
+	// ErrDigestMismatch is returned by [FS.Verify] when a file's contents don't match its digest.
+	ErrDigestMismatch = errors.New("digest mismatch")
+)
+
+// WithDigests computes the sha256 of every regular file while indexing and stores it in the TOC.
+//
+// This means reading (and for gsip, decompressing) the contents of every file, so indexing is slower.
+func WithDigests() Option {
+	return func(o *options) {
+		o.digests = true
+	}
+}
+
+// Digest returns the digest of this entry's contents, or "" if it wasn't computed.
+func (e Entry) Digest() string {
+	return e.Checksum
+}
+
+func isRegular(typ byte) bool {
+	switch typ {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse, tar.TypeCont:
+		return true
+	}
+	return false
+}
+
+func digest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify re-reads the contents of name and checks them against the digest recorded in the TOC.
+func (fsys *FS) Verify(name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tf, ok := f.(*File)
+	if !ok {
+		return fmt.Errorf("verifying %s: unexpected file type %T", name, f)
+	}
+
+	e := tf.Entry
+	if e.Checksum == "" {
+		return fmt.Errorf("verifying %s: %w", name, ErrNoDigest)
+	}
+
+	got, err := digest(f)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", name, err)
+	}
+
+	if got != e.Checksum {
+		return fmt.Errorf("verifying %s: %w: want %s, got %s", name, ErrDigestMismatch, e.Checksum, got)
+	}
+
+	return nil
+}
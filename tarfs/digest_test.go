@@ -0,0 +1,105 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDigests(t *testing.T) {
+	const content = "hello, digests"
+	sum := sha256.Sum256([]byte(content))
+	want := "sha256:" + hex.EncodeToString(sum[:])
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "dir", Typeflag: tar.TypeDir},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Size: int64(len(content))},
+		{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file"},
+		{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file"},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	fsys, err := New(bytes.NewReader(b), int64(len(b)), WithDigests())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"dir":          "",
+		"dir/file":     want,
+		"dir/hardlink": want,
+		"dir/symlink":  "",
+	} {
+		e, err := fsys.Entry(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Digest(); got != want {
+			t.Errorf("Entry(%q).Digest(): want %q, got %q", name, want, got)
+		}
+	}
+
+	// Index agrees, hardlinks included.
+	entries, err := Index(bytes.NewReader(b), WithDigests())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Filename == "dir/hardlink" && e.Digest() != want {
+			t.Errorf("Index: dir/hardlink digest: want %q, got %q", want, e.Digest())
+		}
+	}
+
+	// Round trip through the TOC.
+	toc := &bytes.Buffer{}
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the file contents so Verify has something to find.
+	corrupt := bytes.Clone(b)
+	i := bytes.Index(corrupt, []byte(content))
+	corrupt[i] = 'j'
+
+	for _, tc := range []struct {
+		ra   []byte
+		want error
+	}{
+		{b, nil},
+		{corrupt, ErrDigestMismatch},
+	} {
+		decoded, err := Decode(bytes.NewReader(tc.ra), bytes.NewReader(toc.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"dir/file", "dir/hardlink", "dir/symlink"} {
+			if err := decoded.Verify(name); !errors.Is(err, tc.want) {
+				t.Errorf("Verify(%q): want %v, got %v", name, tc.want, err)
+			}
+		}
+	}
+
+	plain, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Verify("dir/file"); !errors.Is(err, ErrNoDigest) {
+		t.Errorf("Verify without digests: want ErrNoDigest, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"strings"
+)
+
+// WriteTar writes a tar of every entry that matches one of patterns (using [path.Match] syntax),
+// along with everything beneath any matching directory. With no patterns, every entry is written.
+//
+// Entries are written in archive order. Where possible, the raw header and data bytes are copied
+// straight from the underlying io.ReaderAt, coalescing adjacent entries into a single range,
+// so only the parts of a gsip.Reader that back the selected entries are decompressed.
+//
+// Hardlinks to entries that weren't selected are written as regular files so the output is self-contained.
+// Only the final version of a path is written if the archive contains it more than once.
+// Global PAX headers are always written, since they change the meaning of every entry after them.
+func (fsys *FS) WriteTar(w io.Writer, patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return fsys.WriteTarFunc(w, func(e *Entry) bool {
+		return selected(e.Filename, patterns)
+	})
+}
+
+// WriteTarFunc is like [FS.WriteTar] but writes every entry for which keep returns true.
+// As with [FS.Entries], keep sees names relative to the root of fsys, and so does the output.
+func (fsys *FS) WriteTarFunc(w io.Writer, keep func(*Entry) bool) error {
+	tw := tar.NewWriter(w)
+
+	// Raw copies can't be used for entries decoded from an old TOC,
+	// or for views created by [FS.Sub], since the names in the raw headers are the full ones.
+	raw := fsys.size != 0 && fsys.prefix == ""
+
+	// Pending range of raw bytes to copy, which grows as long as selected entries are adjacent.
+	var start, end int64
+	flush := func() error {
+		if start == end {
+			return nil
+		}
+
+		if _, err := io.Copy(w, io.NewSectionReader(fsys.ra, start, end-start)); err != nil {
+			return fmt.Errorf("copying %d bytes at %d: %w", end-start, start, err)
+		}
+
+		start, end = 0, 0
+		return nil
+	}
+
+	written := map[string]struct{}{}
+	for e := range fsys.entries(true) {
+		if e.Header.Typeflag != tar.TypeXGlobalHeader && !keep(fsys.view(e)) {
+			continue
+		}
+
+		written[e.Filename] = struct{}{}
+
+		if e.Header.Typeflag == tar.TypeLink {
+			if _, ok := written[normalize(e.Header.Linkname)]; !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+
+				if err := fsys.writeUnlinked(tw, e); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !raw {
+			if err := fsys.writeEntry(tw, e, fsys.header(e)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Include the padding after the data so the next header is block aligned.
+		dataEnd := e.Offset + e.PhysicalSize()
+		dataEnd += blockPadding(dataEnd)
+
+		if start != end && e.HeaderOffset != end {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if start == end {
+			start = e.HeaderOffset
+		}
+		end = dataEnd
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// selected returns true if name or any of its parents matches one of patterns.
+func selected(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = normalize(pattern)
+		for p := name; ; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if p == "." || p == "/" {
+				break
+			}
+		}
+	}
+
+	return false
+}
+
+// writeUnlinked writes a hardlink as a copy of its target.
+func (fsys *FS) writeUnlinked(tw *tar.Writer, e *Entry) error {
+	target := e
+	for hops := 0; target.Header.Typeflag == tar.TypeLink; hops++ {
+		if hops > MaxHops {
+			return fmt.Errorf("too many links resolving %q", e.Header.Name)
+		}
+
+		next, err := fsys.entry(normalize(target.Header.Linkname))
+		if err != nil {
+			return fmt.Errorf("resolving hardlink %q: %w", e.Header.Name, err)
+		}
+		target = next
+	}
+
+	hdr := target.Header
+	hdr.Name = fsys.header(e).Name
+	return fsys.writeEntry(tw, target, &hdr)
+}
+
+// header returns a copy of e's header with names relative to the root of fsys,
+// which only differ for views created by [FS.Sub].
+func (fsys *FS) header(e *Entry) *tar.Header {
+	hdr := e.Header
+	if fsys.prefix == "" || hdr.Typeflag == tar.TypeXGlobalHeader {
+		return &hdr
+	}
+
+	hdr.Name = fsys.view(e).Filename
+	if strings.HasSuffix(e.Header.Name, "/") {
+		hdr.Name += "/"
+	}
+
+	// Hardlinks we write as links point inside the view (see writeUnlinked).
+	if hdr.Typeflag == tar.TypeLink {
+		hdr.Linkname = strings.TrimPrefix(normalize(hdr.Linkname), fsys.prefix+"/")
+	}
+
+	return &hdr
+}
+
+// writeEntry writes hdr followed by the contents of e with a tar.Writer.
+func (fsys *FS) writeEntry(tw *tar.Writer, e *Entry, hdr *tar.Header) error {
+	out := *hdr
+
+	// Let the writer pick a format that can hold everything.
+	out.Format = tar.FormatUnknown
+
+	if e.Sparse != nil {
+		// archive/tar can't write sparse files, so write out the holes.
+		out.Typeflag = tar.TypeReg
+		out.PAXRecords = maps.Clone(out.PAXRecords)
+		maps.DeleteFunc(out.PAXRecords, func(k, _ string) bool {
+			return strings.HasPrefix(k, "GNU.sparse.")
+		})
+	}
+
+	if err := tw.WriteHeader(&out); err != nil {
+		return fmt.Errorf("writing header for %q: %w", hdr.Name, err)
+	}
+
+	if out.Size != 0 {
+		if _, err := io.Copy(tw, fsys.data(e)); err != nil {
+			return fmt.Errorf("writing %q: %w", hdr.Name, err)
+		}
+	}
+
+	// Pad out the entry so the next raw copy starts on a block boundary.
+	return tw.Flush()
+}
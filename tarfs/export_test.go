@@ -0,0 +1,191 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestWriteTar(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, tc := range []struct {
+		hdr     *tar.Header
+		content string
+	}{
+		{hdr: &tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "everyone"}}},
+		{hdr: &tar.Header{Name: "etc/", Typeflag: tar.TypeDir}},
+		{hdr: &tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg}, content: "root:x:0:0"},
+		{hdr: &tar.Header{Name: "usr/", Typeflag: tar.TypeDir}},
+		{hdr: &tar.Header{Name: "usr/share/", Typeflag: tar.TypeDir}},
+		{hdr: &tar.Header{Name: "usr/share/doc/", Typeflag: tar.TypeDir}},
+		{hdr: &tar.Header{Name: "usr/share/doc/README", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}}, content: "read me"},
+		{hdr: &tar.Header{Name: "usr/share/doc/passwd", Typeflag: tar.TypeLink, Linkname: "etc/passwd"}},
+		{hdr: &tar.Header{Name: "usr/share/doc/symlink", Typeflag: tar.TypeSymlink, Linkname: "README"}},
+		{hdr: &tar.Header{Name: "usr/share/doc/readme", Typeflag: tar.TypeLink, Linkname: "usr/share/doc/README"}},
+		{hdr: &tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir}},
+		{hdr: &tar.Header{Name: "usr/bin/sh", Typeflag: tar.TypeReg}, content: "#!"},
+	} {
+		tc.hdr.Size = int64(len(tc.content))
+		if err := tw.WriteHeader(tc.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(tc.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"usr/share/doc/":        "",
+		"usr/share/doc/README":  "read me",
+		"usr/share/doc/passwd":  "root:x:0:0",
+		"usr/share/doc/symlink": "",
+		"usr/share/doc/readme":  "",
+		"usr/bin/sh":            "#!",
+	}
+
+	check := func(t *testing.T, out []byte) {
+		tr := tar.NewReader(bytes.NewReader(out))
+		got := map[string]string{}
+		global := false
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if hdr.Typeflag == tar.TypeXGlobalHeader {
+				global = hdr.PAXRecords["comment"] == "everyone"
+				continue
+			}
+
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[hdr.Name] = string(content)
+
+			if hdr.Name == "usr/share/doc/passwd" && hdr.Typeflag != tar.TypeReg {
+				t.Errorf("hardlink to unselected file: want regular file, got %q", hdr.Typeflag)
+			}
+			if hdr.Name == "usr/share/doc/README" && hdr.PAXRecords["SCHILY.xattr.user.foo"] != "bar" {
+				t.Errorf("README: lost PAX records: %v", hdr.PAXRecords)
+			}
+		}
+
+		for name, content := range want {
+			if got[name] != content {
+				t.Errorf("%s: want %q, got %q", name, content, got[name])
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("want %v, got %v", slices.Sorted(maps.Keys(want)), slices.Sorted(maps.Keys(got)))
+		}
+		if !global {
+			t.Error("global header wasn't written")
+		}
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		if err := fsys.WriteTar(out, "usr/share/doc", "/usr/bin/s*"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, out.Bytes())
+	})
+
+	t.Run("old TOC", func(t *testing.T) {
+		toc := &bytes.Buffer{}
+		if err := fsys.Encode(toc); err != nil {
+			t.Fatal(err)
+		}
+		old, err := Decode(bytes.NewReader(b), toc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old.size = 0
+
+		out := &bytes.Buffer{}
+		if err := old.WriteTar(out, "usr/share/doc", "/usr/bin/s*"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, out.Bytes())
+	})
+
+	t.Run("Sub", func(t *testing.T) {
+		sub, err := fsys.Sub("usr")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := &bytes.Buffer{}
+		if err := sub.(*FS).WriteTar(out, "share/doc"); err != nil {
+			t.Fatal(err)
+		}
+
+		tr := tar.NewReader(out)
+		got := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeXGlobalHeader {
+				continue
+			}
+
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[hdr.Name] = string(content)
+
+			if hdr.Name == "share/doc/readme" && (hdr.Typeflag != tar.TypeLink || hdr.Linkname != "share/doc/README") {
+				t.Errorf("hardlink within the view: got %q -> %q", hdr.Typeflag, hdr.Linkname)
+			}
+		}
+
+		want := map[string]string{
+			"share/doc/":        "",
+			"share/doc/README":  "read me",
+			"share/doc/passwd":  "root:x:0:0",
+			"share/doc/symlink": "",
+			"share/doc/readme":  "",
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("everything", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		if err := fsys.WriteTar(out); err != nil {
+			t.Fatal(err)
+		}
+
+		// Every entry is adjacent, so this is the original archive.
+		if !bytes.Equal(out.Bytes(), b) {
+			t.Errorf("WriteTar(): output differs from input")
+		}
+	})
+
+	if err := fsys.WriteTar(io.Discard, "["); err == nil {
+		t.Errorf("WriteTar(%q): want error", "[")
+	}
+}
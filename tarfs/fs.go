@@ -0,0 +1,160 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+var (
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.GlobFS     = (*FS)(nil)
+	_ fs.SubFS      = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+)
+
+// ReadFile implements fs.ReadFileFS with a single ReadAt for the whole file.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// This might be a file in a nested archive.
+	e, owner := f.(*File).Entry, f.(*File).fsys
+	if e.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	owner.recordRead(e)
+
+	b := make([]byte, e.Header.Size)
+	n, err := owner.data(e).ReadAt(b, 0)
+	if err != nil && !(err == io.EOF && n == len(b)) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return b, nil
+}
+
+// Glob implements fs.GlobFS by matching pattern against every name in the TOC
+// instead of reading each directory along the way.
+//
+// Unlike [fs.Glob], a pattern with wildcards doesn't descend through symlinks to directories,
+// since those names aren't in the TOC.
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	// Just check that the file exists, like fs.Glob.
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		if _, err := fsys.Stat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	var matches []string
+	match := func(name string) {
+		if fsys.prefix != "" {
+			rel, ok := strings.CutPrefix(name, fsys.prefix+"/")
+			if !ok {
+				return
+			}
+			name = rel
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+
+	for name := range fsys.index {
+		if name != "." {
+			match(name)
+		}
+	}
+
+	// Implicit directories don't have an entry in the index.
+	for name := range fsys.dirs {
+		if _, ok := fsys.index[name]; !ok && name != "." && name != "" {
+			match(name)
+		}
+	}
+
+	slices.Sort(matches)
+
+	return matches, nil
+}
+
+// Sub implements fs.SubFS. The returned fs.FS is a *FS rooted at dir that shares the TOC with fsys,
+// so Entry and the other tarfs-specific methods keep working.
+//
+// As with [fs.Sub], symlinks are resolved relative to the root of the archive, not dir.
+func (fsys *FS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	if dir == "." {
+		return fsys, nil
+	}
+
+	resolved, err := fsys.resolve(fsys.full(dir), true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: err}
+	}
+
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrNotExist}
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: errors.New("not a directory")}
+	}
+
+	sub := &FS{
+		ra:     fsys.ra,
+		files:  fsys.files,
+		index:  fsys.index,
+		dirs:   fsys.dirs,
+		root:   fsys.root,
+		size:   fsys.size,
+		prof:   fsys.prof,
+		prefix: resolved,
+		report: fsys.report,
+		opts:   fsys.opts,
+		nested: fsys.nested,
+	}
+
+	// The root of the archive is its own root.
+	if resolved == "." {
+		sub.prefix = ""
+	}
+
+	return sub, nil
+}
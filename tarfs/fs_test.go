@@ -0,0 +1,168 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+type countingReaderAt struct {
+	ra    *bytes.Reader
+	reads atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads.Add(1)
+	return c.ra.ReadAt(p, off)
+}
+
+func TestSub(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, tc := range []struct {
+		hdr     *tar.Header
+		content []byte
+	}{
+		{hdr: &tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: &tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644}, content: []byte("ID=test\n")},
+		{hdr: &tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: &tar.Header{Name: "usr/lib/os-release", Typeflag: tar.TypeSymlink, Linkname: "/etc/os-release"}},
+		{hdr: &tar.Header{Name: "usr/lib/big.so", Typeflag: tar.TypeReg, Mode: 0o644}, content: big},
+		{hdr: &tar.Header{Name: "usr/lib/small.so", Typeflag: tar.TypeReg, Mode: 0o644}, content: []byte("elf")},
+		{hdr: &tar.Header{Name: "etc/small.so", Typeflag: tar.TypeLink, Linkname: "usr/lib/small.so"}},
+	} {
+		tc.hdr.Size = int64(len(tc.content))
+		if err := tw.WriteHeader(tc.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(tc.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ra := &countingReaderAt{ra: bytes.NewReader(buf.Bytes())}
+	fsys, err := New(ra, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadFile should be a single ReadAt, no matter how big the file is.
+	ra.reads.Store(0)
+	got, err := fsys.ReadFile("usr/lib/big.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Errorf("ReadFile: got %d bytes, want %d", len(got), len(big))
+	}
+	if n := ra.reads.Load(); n != 1 {
+		t.Errorf("ReadFile: want 1 ReadAt, got %d", n)
+	}
+
+	if _, err := fsys.ReadFile("usr"); err == nil {
+		t.Errorf("ReadFile(usr): want error")
+	}
+
+	// usr/lib has no entry of its own, but should still match.
+	matches, err := fsys.Glob("usr/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"usr/lib"}; !slices.Equal(matches, want) {
+		t.Errorf("Glob(usr/*): want %q, got %q", want, matches)
+	}
+
+	matches, err = fsys.Glob("*/*/*.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"usr/lib/big.so", "usr/lib/small.so"}; !slices.Equal(matches, want) {
+		t.Errorf("Glob(*/*/*.so): want %q, got %q", want, matches)
+	}
+
+	if _, err := fsys.Glob("["); err == nil {
+		t.Errorf("Glob([): want error")
+	}
+
+	sub, err := fs.Sub(fsys, "usr/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib, ok := sub.(*FS)
+	if !ok {
+		t.Fatalf("Sub: want *FS, got %T", sub)
+	}
+
+	if err := fstest.TestFS(lib, "big.so", "small.so", "os-release"); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := lib.Entry("small.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Filename != "small.so" {
+		t.Errorf("Entry(small.so): got %q", e.Filename)
+	}
+
+	etc, err := fsys.Sub("etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Absolute symlinks and hardlinks are still relative to the root of the archive.
+	for _, tc := range []struct {
+		fsys fs.FS
+		name string
+		want string
+	}{
+		{lib, "os-release", "ID=test\n"},
+		{etc, "small.so", "elf"},
+	} {
+		got, err := fs.ReadFile(tc.fsys, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("ReadFile(%s): want %q, got %q", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := lib.EvalSymlinks("os-release"); err == nil {
+		t.Errorf("EvalSymlinks(os-release): want error for a link outside of the sub FS")
+	}
+
+	// Names are relative to the view, so they can be passed back to it.
+	var names []string
+	for e := range lib.Entries() {
+		names = append(names, e.Filename)
+		if _, err := lib.Stat(e.Filename); err != nil {
+			t.Errorf("Stat(%s): %v", e.Filename, err)
+		}
+	}
+	if want := []string{"os-release", "big.so", "small.so"}; !slices.Equal(names, want) {
+		t.Errorf("Entries(): want %q, got %q", want, names)
+	}
+
+	var globbed []string
+	for e := range lib.Query(MustGlob("*.so")) {
+		globbed = append(globbed, e.Filename)
+	}
+	if want := []string{"big.so", "small.so"}; !slices.Equal(globbed, want) {
+		t.Errorf("Query(*.so): want %q, got %q", want, globbed)
+	}
+
+	if _, err := fsys.Sub("etc/os-release"); err == nil {
+		t.Errorf("Sub(etc/os-release): want error for a file")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// These limits are for indexing untrusted archives. Zero means no limit.
+//
+// Note that archive/tar already refuses PAX headers and GNU long names over 1MiB,
+// so these are only useful to go lower than that.
+
+// WithMaxEntries fails with an [*EntryLimitError] if the archive has more than n entries.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxNameLength fails with a [*NameLimitError] if any name or link target is longer than n bytes.
+func WithMaxNameLength(n int) Option {
+	return func(o *options) {
+		o.maxNameLength = n
+	}
+}
+
+// WithMaxPAXSize fails with a [*PAXLimitError] if the PAX records for any entry take up more than n bytes.
+func WithMaxPAXSize(n int64) Option {
+	return func(o *options) {
+		o.maxPAXSize = n
+	}
+}
+
+// EntryLimitError is returned when an archive has more than Limit entries.
+type EntryLimitError struct {
+	Limit int
+}
+
+func (e *EntryLimitError) Error() string {
+	return fmt.Sprintf("archive has more than %d entries", e.Limit)
+}
+
+// NameLimitError is returned when a name or link target is longer than Limit.
+type NameLimitError struct {
+	// Name is truncated to Limit bytes.
+	Name   string
+	Length int
+	Limit  int
+}
+
+func (e *NameLimitError) Error() string {
+	return fmt.Sprintf("name %q... is %d bytes, over the limit of %d", e.Name, e.Length, e.Limit)
+}
+
+// PAXLimitError is returned when the PAX records for an entry are larger than Limit.
+type PAXLimitError struct {
+	Name  string
+	Size  int64
+	Limit int64
+}
+
+func (e *PAXLimitError) Error() string {
+	return fmt.Sprintf("PAX records for %q are %d bytes, over the limit of %d", e.Name, e.Size, e.Limit)
+}
+
+// check enforces the limits for the count'th entry.
+func (o *options) check(hdr *tar.Header, raw *rawHeader, count int) error {
+	if o.maxEntries != 0 && count > o.maxEntries {
+		return &EntryLimitError{Limit: o.maxEntries}
+	}
+
+	if o.maxNameLength != 0 {
+		for _, name := range []string{hdr.Name, hdr.Linkname} {
+			if len(name) > o.maxNameLength {
+				return &NameLimitError{
+					Name:   name[:o.maxNameLength],
+					Length: len(name),
+					Limit:  o.maxNameLength,
+				}
+			}
+		}
+	}
+
+	if o.maxPAXSize != 0 && raw.paxSize > o.maxPAXSize {
+		return &PAXLimitError{
+			Name:  hdr.Name,
+			Size:  raw.paxSize,
+			Limit: o.maxPAXSize,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := range 10 {
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file%d", i), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: strings.Repeat("a", 200), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:       "xattrs",
+		Typeflag:   tar.TypeReg,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.big": strings.Repeat("x", 4096)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	var (
+		entries *EntryLimitError
+		name    *NameLimitError
+		pax     *PAXLimitError
+	)
+
+	for _, tc := range []struct {
+		name   string
+		opts   []Option
+		target any
+	}{
+		{"entries", []Option{WithMaxEntries(5)}, &entries},
+		{"name", []Option{WithMaxNameLength(100)}, &name},
+		{"pax", []Option{WithMaxPAXSize(1024)}, &pax},
+		{"none", []Option{WithMaxEntries(12), WithMaxNameLength(200), WithMaxPAXSize(8192)}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(bytes.NewReader(b), int64(len(b)), tc.opts...)
+			if tc.target == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.As(err, tc.target) {
+				t.Fatalf("New: want %T, got %v", tc.target, err)
+			}
+
+			// Index enforces the same limits.
+			if _, err := Index(bytes.NewReader(b), tc.opts...); !errors.As(err, tc.target) {
+				t.Fatalf("Index: want %T, got %v", tc.target, err)
+			}
+		})
+	}
+
+	if entries.Limit != 5 {
+		t.Errorf("EntryLimitError: %+v", entries)
+	}
+	if name.Length != 200 || len(name.Name) != 100 {
+		t.Errorf("NameLimitError: %+v", name)
+	}
+	if pax.Name != "xattrs" || pax.Size <= 4096 {
+		t.Errorf("PAXLimitError: %+v", pax)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+)
+
+// nested caches the FS for each archive inside this one, keyed by Filename.
+type nested struct {
+	sync.Mutex
+	fss map[string]*mount
+
+	// number of entries we've made mount points for, so lookups can skip looking for them if there aren't any
+	points int
+}
+
+// mount is a nested archive, which is indexed at most once at a time without holding up any others.
+type mount struct {
+	sync.Mutex
+	fsys *FS
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// WithMounts exposes entries named *.tar, *.tar.gz or *.tgz as directories containing
+// the contents of that archive, e.g. "images/foo.tar/etc/passwd". See [FS.OpenArchive].
+//
+// An entry that's named like an archive but isn't one goes back to being a regular file
+// the first time something looks inside it.
+//
+// Lookups through a mount point don't show up in [FS.EvalSymlinks], [FS.Glob] or [FS.Entries].
+func WithMounts() Option {
+	return func(o *options) {
+		o.mount = true
+	}
+}
+
+// WithGzipOptions passes opts to [gsip.NewReader] for gzipped archives opened by [FS.OpenArchive] or [WithMounts],
+// e.g. [gsip.WithMaxRatio] so that a gzip bomb inside an archive is held to the same limits as the archive itself.
+// [Tolerant] implies [gsip.Tolerant].
+func WithGzipOptions(opts ...gsip.Option) Option {
+	return func(o *options) {
+		o.gzip = append(o.gzip, opts...)
+	}
+}
+
+func isArchive(e *Entry) bool {
+	if !isRegular(e.Header.Typeflag) {
+		return false
+	}
+
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(e.Filename, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mountPoint is the fs.FileInfo for an archive that we're exposing as a directory.
+type mountPoint struct {
+	fs.FileInfo
+
+	// Set once we find out it isn't an archive after all, so it goes back to being a regular file.
+	plain atomic.Bool
+}
+
+func (m *mountPoint) Mode() fs.FileMode {
+	if m.plain.Load() {
+		return m.FileInfo.Mode()
+	}
+	return fs.ModeDir | m.FileInfo.Mode().Perm()
+}
+
+func (m *mountPoint) IsDir() bool {
+	return !m.plain.Load()
+}
+
+func (m *mountPoint) Size() int64 {
+	if m.plain.Load() {
+		return m.FileInfo.Size()
+	}
+	return 0
+}
+
+// OpenArchive opens the tar or tar.gz file at name as its own FS, using the same options as fsys.
+// A gzipped archive is read through a [gsip.Reader], so only what's needed gets decompressed.
+//
+// The result is cached, so opening the same archive again is cheap.
+func (fsys *FS) OpenArchive(name string) (*FS, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "openarchive", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.open(fsys.full(name), 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "openarchive", Path: name, Err: err}
+	}
+
+	inner, err := fsys.openArchive(f.(*File).Entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "openarchive", Path: name, Err: err}
+	}
+
+	return inner, nil
+}
+
+func (fsys *FS) openArchive(e *Entry) (*FS, error) {
+	if !isRegular(e.Header.Typeflag) {
+		return nil, fmt.Errorf("%s is not a regular file", e.Filename)
+	}
+
+	fsys.nested.Lock()
+	m, ok := fsys.nested.fss[e.Filename]
+	if !ok {
+		m = &mount{}
+		fsys.nested.fss[e.Filename] = m
+	}
+	fsys.nested.Unlock()
+
+	// Only wait on someone else indexing this archive, not every archive.
+	m.Lock()
+	defer m.Unlock()
+
+	if m.fsys != nil {
+		return m.fsys, nil
+	}
+
+	var (
+		ra   io.ReaderAt = fsys.data(e)
+		size             = e.Header.Size
+	)
+
+	magic := make([]byte, len(gzipMagic))
+	if _, err := ra.ReadAt(magic, 0); err == nil && bytes.Equal(magic, gzipMagic) {
+		opts := fsys.opts.gzip
+		if fsys.opts.tolerant {
+			opts = append(slices.Clip(opts), gsip.Tolerant())
+		}
+
+		zr, err := gsip.NewReader(ra, size, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		// We don't know the uncompressed size.
+		ra, size = zr, -1
+	}
+
+	inner, err := build(ra, size, fsys.opts)
+	if err != nil {
+		return nil, fmt.Errorf("indexing %s: %w", e.Filename, err)
+	}
+
+	m.fsys = inner
+
+	return inner, nil
+}
+
+// mounted finds the first mounted archive along name, returning its FS and the rest of name within it.
+func (fsys *FS) mounted(name string) (*FS, string, bool, error) {
+	if !fsys.opts.mount || fsys.nested.points == 0 {
+		return nil, "", false, nil
+	}
+
+	// Resolve name once, stopping at the first mount point along the way.
+	resolved, rest, err := fsys.walk(name, true, isMountPoint)
+	if err != nil {
+		// Let the caller come up with the right error.
+		return nil, "", false, nil
+	}
+
+	e, ok := fsys.lookup(resolved)
+	if !ok || !isMountPoint(e.fi) {
+		return nil, "", false, nil
+	}
+
+	inner, err := fsys.openArchive(e)
+	if err != nil {
+		if tolerable(err) {
+			// It's named like an archive but isn't one, so it's just a file.
+			e.fi.(*mountPoint).plain.Store(true)
+			return nil, "", false, nil
+		}
+		return nil, "", false, err
+	}
+
+	// A symlink can leave ".." after the mount point, which path.Join would quietly apply
+	// within the mounted archive instead of the one it's in.
+	if slices.Contains(rest, "..") {
+		return nil, "", false, fmt.Errorf("%w: %s leaves the archive mounted at %s", fs.ErrInvalid, name, e.Filename)
+	}
+
+	within := path.Join(rest...)
+	if within == "" {
+		within = "."
+	}
+
+	return inner, within, true, nil
+}
+
+func isMountPoint(fi fs.FileInfo) bool {
+	m, ok := fi.(*mountPoint)
+	return ok && !m.plain.Load()
+}
+
+// openMount opens the root of a mounted archive, named after its mount point.
+func (fsys *FS) openMount(inner *FS, name string) (*File, error) {
+	resolved, err := fsys.resolve(fsys.full(name), true)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	root := *inner.root
+	root.fi = e.fi
+
+	return &File{
+		Entry: &root,
+		fsys:  inner,
+		sr:    io.NewSectionReader(bytes.NewReader(nil), 0, 0),
+	}, nil
+}
@@ -0,0 +1,274 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+)
+
+type tarFile struct {
+	name, content string
+}
+
+func makeTar(t *testing.T, files ...tarFile) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(f.content))}
+		if f.content == "/" {
+			hdr = &tar.Header{Name: f.name, Typeflag: tar.TypeDir, Mode: 0o755}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(f.content))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNested(t *testing.T) {
+	inner := makeTar(t, tarFile{"etc/", "/"}, tarFile{"etc/passwd", "root:x:0:0"})
+
+	zbuf := &bytes.Buffer{}
+	zw := gzip.NewWriter(zbuf)
+	zw.Write(inner)
+	zw.Close()
+
+	outer := makeTar(t,
+		tarFile{"images/", "/"},
+		tarFile{"images/inner.tar", string(inner)},
+		tarFile{"images/inner.tar.gz", zbuf.String()},
+		tarFile{"README", "hello"},
+	)
+
+	t.Run("OpenArchive", func(t *testing.T) {
+		fsys, err := New(bytes.NewReader(outer), int64(len(outer)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"images/inner.tar", "images/inner.tar.gz"} {
+			nfs, err := fsys.OpenArchive(name)
+			if err != nil {
+				t.Fatalf("OpenArchive(%q): %v", name, err)
+			}
+
+			got, err := fs.ReadFile(nfs, "etc/passwd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "root:x:0:0" {
+				t.Errorf("%s: etc/passwd = %q", name, got)
+			}
+
+			again, err := fsys.OpenArchive(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != nfs {
+				t.Errorf("OpenArchive(%q): want cached FS", name)
+			}
+		}
+
+		if _, err := fsys.OpenArchive("README"); err == nil {
+			t.Errorf("OpenArchive(README): want error")
+		}
+
+		// Without mounts, archives are just files.
+		fi, err := fsys.Stat("images/inner.tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Mode().IsRegular() {
+			t.Errorf("Stat(images/inner.tar): want regular file, got %v", fi.Mode())
+		}
+	})
+
+	t.Run("WithMounts", func(t *testing.T) {
+		fsys, err := New(bytes.NewReader(outer), int64(len(outer)), WithMounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"images/inner.tar", "images/inner.tar.gz"} {
+			fi, err := fsys.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if base := name[len("images/"):]; !fi.IsDir() || fi.Name() != base {
+				t.Errorf("Stat(%q): want dir named %q, got %v %q", name, base, fi.Mode(), fi.Name())
+			}
+
+			des, err := fsys.ReadDir(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(des) != 1 || des[0].Name() != "etc" {
+				t.Errorf("ReadDir(%q): got %v", name, des)
+			}
+
+			got, err := fsys.ReadFile(name + "/etc/passwd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "root:x:0:0" {
+				t.Errorf("%s/etc/passwd = %q", name, got)
+			}
+
+			if _, err := fsys.Open(name + "/missing"); err == nil {
+				t.Errorf("Open(%s/missing): want error", name)
+			}
+		}
+
+		des, err := fsys.ReadDir("images")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, de := range des {
+			if !de.IsDir() {
+				t.Errorf("ReadDir(images): %s is not a dir", de.Name())
+			}
+		}
+
+		var walked []string
+		if err := fs.WalkDir(fsys, "images", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			walked = append(walked, p)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(walked) != 7 {
+			t.Errorf("WalkDir: got %v", walked)
+		}
+	})
+
+	t.Run("WithGzipOptions", func(t *testing.T) {
+		bomb := &bytes.Buffer{}
+		zw := gzip.NewWriter(bomb)
+		zw.Write(makeTar(t, tarFile{"zeros", strings.Repeat("\x00", 4*gsip.MinRatioCheck)}))
+		zw.Close()
+
+		outer := makeTar(t,
+			tarFile{"inner.tar", string(inner)},
+			tarFile{"bomb.tar.gz", bomb.String()},
+		)
+
+		fsys, err := New(bytes.NewReader(outer), int64(len(outer)), WithMounts(), WithGzipOptions(gsip.WithMaxRatio(10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ratio *gsip.RatioLimitError
+		if _, err := fsys.OpenArchive("bomb.tar.gz"); !errors.As(err, &ratio) {
+			t.Errorf("OpenArchive(bomb.tar.gz): want RatioLimitError, got %v", err)
+		}
+		if _, err := fsys.Stat("bomb.tar.gz/zeros"); !errors.As(err, &ratio) {
+			t.Errorf("Stat(bomb.tar.gz/zeros): want RatioLimitError, got %v", err)
+		}
+
+		if _, err := fsys.ReadFile("inner.tar/etc/passwd"); err != nil {
+			t.Errorf("ReadFile(inner.tar/etc/passwd): %v", err)
+		}
+	})
+	t.Run("NotAnArchive", func(t *testing.T) {
+		outer := makeTar(t,
+			tarFile{"notes.tar", "just some notes"},
+			tarFile{"inner.tar", string(inner)},
+		)
+
+		fsys, err := New(bytes.NewReader(outer), int64(len(outer)), WithMounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var walked []string
+		if err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			walked = append(walked, p)
+			return nil
+		}); err != nil {
+			t.Fatalf("WalkDir: %v", err)
+		}
+		if len(walked) != 5 {
+			t.Errorf("WalkDir: got %v", walked)
+		}
+
+		fi, err := fsys.Stat("notes.tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Mode().IsRegular() || fi.Size() != int64(len("just some notes")) {
+			t.Errorf("Stat(notes.tar): want a regular file, got %v with %d bytes", fi.Mode(), fi.Size())
+		}
+		if got, err := fsys.ReadFile("notes.tar"); err != nil || string(got) != "just some notes" {
+			t.Errorf("ReadFile(notes.tar): got %q, %v", got, err)
+		}
+	})
+
+	t.Run("DotDot", func(t *testing.T) {
+		// A symlink if link is set, a dir if name ends with a slash, otherwise a file with content.
+		type entry struct{ name, link, content string }
+		tarball := func(entries ...entry) []byte {
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			for _, e := range entries {
+				hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(e.content))}
+				if e.link != "" {
+					hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.link}
+				} else if strings.HasSuffix(e.name, "/") {
+					hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeDir, Mode: 0o755}
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+				tw.Write([]byte(e.content))
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		}
+
+		inner := tarball(
+			entry{name: "etc/ssl/"},
+			entry{name: "etc/passwd", content: "etc"},
+			entry{name: "passwd", content: "root"},
+			entry{name: "ssl", link: "etc/ssl"},
+		)
+		outer := tarball(
+			entry{name: "inner.tar", content: string(inner)},
+			entry{name: "sneaky", link: "inner.tar/ssl/../passwd"},
+		)
+
+		fsys, err := New(bytes.NewReader(outer), int64(len(outer)), WithMounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Cleaned lexically, this would be inner.tar's passwd rather than its etc/passwd.
+		if got, err := fsys.ReadFile("sneaky"); err == nil {
+			t.Errorf("ReadFile(sneaky): want error, got %q", got)
+		}
+		if got, err := fsys.ReadFile("inner.tar/etc/passwd"); err != nil || string(got) != "etc" {
+			t.Errorf("ReadFile(inner.tar/etc/passwd): got %q, %v", got, err)
+		}
+	})
+}
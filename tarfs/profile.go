@@ -0,0 +1,103 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Profile is a log of which files were read from an FS, in the order they were first read.
+//
+// Container startup tends to touch the same files every time, so a Profile recorded
+// from one run can be used to prefetch those files for the next one.
+type Profile struct {
+	Accesses []Access
+}
+
+// Access records the data of a single file that was read.
+type Access struct {
+	Name string
+
+	// Offset and Length of the file's data within the archive.
+	Offset int64
+	Length int64
+}
+
+func (p *Profile) Encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(p)
+}
+
+func DecodeProfile(r io.Reader) (*Profile, error) {
+	p := &Profile{}
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+type profiler struct {
+	sync.Mutex
+	seen     map[*Entry]struct{}
+	accesses []Access
+}
+
+// Record starts recording a [Profile] of every file read from fsys.
+// Calling Record again discards anything recorded so far.
+func (fsys *FS) Record() {
+	fsys.prof.Store(&profiler{
+		seen: map[*Entry]struct{}{},
+	})
+}
+
+// Profile returns what has been recorded since the last call to [FS.Record], or nil if it was never called.
+func (fsys *FS) Profile() *Profile {
+	p := fsys.prof.Load()
+	if p == nil {
+		return nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	return &Profile{
+		Accesses: slices.Clone(p.accesses),
+	}
+}
+
+// recordRead is called on every read, so it needs to be cheap when we aren't recording.
+func (fsys *FS) recordRead(e *Entry) {
+	p := fsys.prof.Load()
+	if p == nil || e.PhysicalSize() == 0 {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.seen[e]; ok {
+		return
+	}
+	p.seen[e] = struct{}{}
+
+	p.accesses = append(p.accesses, Access{
+		Name:   e.Filename,
+		Offset: e.Offset,
+		Length: e.PhysicalSize(),
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"io/fs"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Filter selects entries for [FS.Query].
+type Filter func(*Entry) bool
+
+// Query yields every entry (in archive order, see [FS.Entries]) that passes all of filters.
+//
+// This only looks at the TOC, so it doesn't read anything from the archive.
+func (fsys *FS) Query(filters ...Filter) iter.Seq[*Entry] {
+	return func(yield func(*Entry) bool) {
+		for e := range fsys.Entries() {
+			if !all(e, filters) {
+				continue
+			}
+
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+func all(e *Entry, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Glob matches entries whose names match pattern, using the same syntax as [fs.Glob] and [path.Match].
+//
+// As an extension, a "**" path element matches zero or more directories, so "**/*.so*" matches
+// shared libraries anywhere and "usr/**" matches usr and everything under it.
+//
+// The only possible error is [path.ErrBadPattern].
+func Glob(pattern string) (Filter, error) {
+	elems, err := compileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e *Entry) bool {
+		return matchGlob(elems, strings.Split(e.Filename, "/"))
+	}, nil
+}
+
+// MustGlob is like [Glob] but panics if pattern is malformed.
+func MustGlob(pattern string) Filter {
+	f, err := Glob(pattern)
+	if err != nil {
+		panic("tarfs: Glob(" + pattern + "): " + err.Error())
+	}
+	return f
+}
+
+func compileGlob(pattern string) ([]string, error) {
+	elems := strings.Split(normalize(pattern), "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	return elems, nil
+}
+
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try consuming every possible number of directories.
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// OfType matches entries whose [fs.FileMode] type is one of types.
+// Regular files (including hardlinks) have a type of 0.
+func OfType(types ...fs.FileMode) Filter {
+	return func(e *Entry) bool {
+		return slices.Contains(types, e.Type())
+	}
+}
+
+// HasMode matches entries with all of the given mode bits set,
+// e.g. fs.ModeSetuid for setuid files or 0o002 for world-writable files.
+func HasMode(bits fs.FileMode) Filter {
+	return func(e *Entry) bool {
+		return e.fi.Mode()&bits == bits
+	}
+}
+
+// MinSize matches entries at least n bytes long.
+func MinSize(n int64) Filter {
+	return func(e *Entry) bool {
+		return e.Header.Size >= n
+	}
+}
+
+// MaxSize matches entries at most n bytes long.
+func MaxSize(n int64) Filter {
+	return func(e *Entry) bool {
+		return e.Header.Size <= n
+	}
+}
+
+// ModifiedAfter matches entries with a modification time after t.
+func ModifiedAfter(t time.Time) Filter {
+	return func(e *Entry) bool {
+		return e.Header.ModTime.After(t)
+	}
+}
+
+// ModifiedBefore matches entries with a modification time before t.
+func ModifiedBefore(t time.Time) Filter {
+	return func(e *Entry) bool {
+		return e.Header.ModTime.Before(t)
+	}
+}
+
+// OwnedBy matches entries with the given uid.
+func OwnedBy(uid int) Filter {
+	return func(e *Entry) bool {
+		return e.Header.Uid == uid
+	}
+}
+
+// GroupOwnedBy matches entries with the given gid.
+func GroupOwnedBy(gid int) Filter {
+	return func(e *Entry) bool {
+		return e.Header.Gid == gid
+	}
+}
+
+// OwnedByName matches entries with the given user name.
+func OwnedByName(uname string) Filter {
+	return func(e *Entry) bool {
+		return e.Header.Uname == uname
+	}
+}
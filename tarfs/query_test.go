@@ -0,0 +1,105 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	old, recent := time.Unix(1000000000, 0), time.Unix(1700000000, 0)
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: old},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: old},
+		{Name: "usr/bin/sudo", Typeflag: tar.TypeReg, Mode: 0o4755, Size: 100, ModTime: recent},
+		{Name: "usr/bin/big", Typeflag: tar.TypeReg, Mode: 0o755, Size: 20 << 20, Uid: 1000, Uname: "user", ModTime: old},
+		{Name: "usr/lib/libc.so.6", Typeflag: tar.TypeReg, Mode: 0o644, Size: 10, ModTime: old},
+		{Name: "usr/lib/libc.so", Typeflag: tar.TypeSymlink, Linkname: "libc.so.6", ModTime: old},
+		{Name: "lib.so", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: old},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5, Gid: 42, ModTime: recent},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(make([]byte, hdr.Size))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		filters []Filter
+		want    []string
+	}{{
+		name:    "everything",
+		filters: nil,
+		want:    []string{"usr", "usr/bin", "usr/bin/sudo", "usr/bin/big", "usr/lib/libc.so.6", "usr/lib/libc.so", "lib.so", "etc/passwd"},
+	}, {
+		name:    "setuid",
+		filters: []Filter{HasMode(fs.ModeSetuid)},
+		want:    []string{"usr/bin/sudo"},
+	}, {
+		name:    "large files under usr",
+		filters: []Filter{MustGlob("/usr/**"), OfType(0), MinSize(10 << 20)},
+		want:    []string{"usr/bin/big"},
+	}, {
+		name:    "shared objects",
+		filters: []Filter{MustGlob("**/*.so*")},
+		want:    []string{"usr/lib/libc.so.6", "usr/lib/libc.so", "lib.so"},
+	}, {
+		name:    "single level glob",
+		filters: []Filter{MustGlob("usr/*")},
+		want:    []string{"usr/bin"},
+	}, {
+		name:    "symlinks",
+		filters: []Filter{OfType(fs.ModeSymlink)},
+		want:    []string{"usr/lib/libc.so"},
+	}, {
+		name:    "small regular files",
+		filters: []Filter{OfType(0), MaxSize(5)},
+		want:    []string{"lib.so", "etc/passwd"},
+	}, {
+		name:    "recent",
+		filters: []Filter{ModifiedAfter(old)},
+		want:    []string{"usr/bin/sudo", "etc/passwd"},
+	}, {
+		name:    "old",
+		filters: []Filter{ModifiedBefore(recent), MustGlob("usr/bin/*")},
+		want:    []string{"usr/bin/big"},
+	}, {
+		name:    "owner",
+		filters: []Filter{OwnedBy(1000), OwnedByName("user")},
+		want:    []string{"usr/bin/big"},
+	}, {
+		name:    "group",
+		filters: []Filter{GroupOwnedBy(42)},
+		want:    []string{"etc/passwd"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for e := range fsys.Query(tc.filters...) {
+				got = append(got, e.Filename)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Query: want %q, got %q", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := Glob("usr/["); err == nil {
+		t.Errorf("Glob(%q): want error", "usr/[")
+	}
+}
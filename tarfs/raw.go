@@ -0,0 +1,321 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const blockSize = 512
+
+// blockPadding returns the number of bytes needed to pad n to a block boundary.
+func blockPadding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+// recordSize is the default blocking factor of tar (20 blocks), which archives are often padded out to.
+const recordSize = 20 * blockSize
+
+// skipPadding reads the zero blocks after the trailer, up to the end of the record,
+// and returns where the archive ends. It stops early at EOF or anything that isn't zeros,
+// so it never reads more than a record past the trailer, even if the size is unknown.
+func skipPadding(rc *recorder) (int64, error) {
+	end := rc.n
+	blk := make([]byte, blockSize)
+	for end%recordSize != 0 {
+		if _, err := io.ReadFull(rc, blk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, err
+		}
+		if !bytes.Equal(blk, zeroBlock[:]) {
+			break
+		}
+		end += blockSize
+	}
+	return end, nil
+}
+
+var zeroBlock [blockSize]byte
+
+// recorder counts the bytes read from r and keeps a copy of every byte at or after from.
+//
+// archive/tar hides the raw header blocks from us, so we use this to capture them as they go by.
+type recorder struct {
+	r io.Reader
+	n int64
+
+	from int64
+	buf  []byte
+}
+
+func (rc *recorder) Read(p []byte) (int, error) {
+	n, err := rc.r.Read(p)
+	if end := rc.n + int64(n); end > rc.from {
+		start := max(rc.from-rc.n, 0)
+		rc.buf = append(rc.buf, p[start:n]...)
+	}
+	rc.n += int64(n)
+	return n, err
+}
+
+// reset starts recording at from.
+func (rc *recorder) reset(from int64) {
+	rc.from = from
+	rc.buf = rc.buf[:0]
+}
+
+// rawHeader is every block that archive/tar consumed to produce a single tar.Header.
+//
+// That includes any PAX or GNU long name headers, the main header, old GNU sparse
+// extension blocks, and the PAX 1.0 sparse map (which lives at the start of the data).
+type rawHeader struct {
+	buf []byte
+
+	// offset of the main header block within buf
+	mainOffset int
+
+	// offset of the end of the main header (and any sparse extension blocks) within buf
+	mainEnd int
+
+	// number of bytes in the data section, starting at mainEnd
+	size int64
+
+	// total size of the PAX records read with this header: its own extended headers, or the records
+	// of a global header. Earlier global headers aren't counted again, since each was checked on its own.
+	paxSize int64
+}
+
+func (raw *rawHeader) main() []byte {
+	return raw.buf[raw.mainOffset : raw.mainOffset+blockSize]
+}
+
+// sparseMap1x0 returns the PAX 1.0 sparse map that archive/tar consumed from the data section.
+func (raw *rawHeader) sparseMap1x0() []byte {
+	return raw.buf[raw.mainEnd:]
+}
+
+// dataEnd returns the end of the data section (before padding) relative to the start of buf.
+func (raw *rawHeader) dataEnd() int64 {
+	return int64(raw.mainEnd) + raw.size
+}
+
+// parseRawHeader figures out the layout of the blocks behind a header and how much data follows them.
+func parseRawHeader(buf []byte) (*rawHeader, error) {
+	raw := &rawHeader{buf: buf}
+
+	var paxSize string
+	for off := 0; ; {
+		if off+blockSize > len(buf) {
+			return nil, fmt.Errorf("raw header truncated at %d of %d bytes", off, len(buf))
+		}
+
+		blk := buf[off : off+blockSize]
+		typ := blk[156]
+
+		size, err := parseNumeric(blk[124:136])
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			end := off + blockSize + int(size)
+			if size < 0 || end > len(buf) {
+				return nil, fmt.Errorf("raw header truncated: %d byte %q header at %d of %d bytes", size, typ, off, len(buf))
+			}
+
+			if typ == tar.TypeXHeader {
+				raw.paxSize += size
+				if v, ok := paxRecord(buf[off+blockSize:end], "size"); ok {
+					paxSize = v
+				}
+			}
+
+			off = end + int(blockPadding(size))
+			continue
+		}
+
+		raw.mainOffset = off
+		raw.mainEnd = off + blockSize
+
+		if paxSize != "" {
+			size, err = strconv.ParseInt(paxSize, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PAX size %q: %w", paxSize, err)
+			}
+		}
+
+		switch typ {
+		case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+			// archive/tar ignores the size for these types.
+			size = 0
+		case tar.TypeGNUSparse:
+			// Skip over any sparse extension blocks.
+			for ext := blk[gnuIsExtendedOffset]; ext != 0; ext = buf[raw.mainEnd-blockSize+sparseExtIsExtendedOffs] {
+				raw.mainEnd += blockSize
+				if raw.mainEnd > len(buf) {
+					return nil, fmt.Errorf("raw header truncated in sparse extension block")
+				}
+			}
+		}
+
+		raw.size = size
+
+		if typ == tar.TypeXGlobalHeader {
+			raw.paxSize += size
+		}
+
+		return raw, nil
+	}
+}
+
+// paxRecord finds a single record in the data section of a PAX header.
+func paxRecord(b []byte, key string) (string, bool) {
+	for len(b) > 0 {
+		// Each record is "%d %s=%s\n" where the leading number is the length of the whole record.
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 0 {
+			return "", false
+		}
+
+		n, err := strconv.Atoi(string(b[:sp]))
+		if err != nil || n <= sp || n > len(b) {
+			return "", false
+		}
+
+		k, v, ok := bytes.Cut(b[sp+1:n], []byte("="))
+		if ok && string(k) == key {
+			return string(bytes.TrimSuffix(v, []byte("\n"))), true
+		}
+
+		b = b[n:]
+	}
+
+	return "", false
+}
+
+// parseNumeric parses a numeric header field, which is either octal or (for large values) base-256.
+func parseNumeric(b []byte) (int64, error) {
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		// Base-256, two's complement, big endian. We don't expect negative numbers.
+		var n int64
+		for i, c := range b {
+			if i == 0 {
+				c &= 0x7f
+			}
+			if n>>56 != 0 {
+				return 0, fmt.Errorf("numeric field overflows int64")
+			}
+			n = n<<8 | int64(c)
+		}
+		return n, nil
+	}
+
+	s := string(bytes.Trim(b, " \x00"))
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(s, 8, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid numeric field %q: %w", b, err)
+	}
+
+	return n, nil
+}
+
+// scanner wraps a tar.Reader to recover the things it doesn't tell us, like
+// where each header starts and where its data really ends.
+type scanner struct {
+	tr   *tar.Reader
+	rec  *recorder
+	opts *options
+
+	// offset of the next header
+	next int64
+
+	// offset of the header that the last call to Next started reading,
+	// so we know where an entry that failed partway through began
+	start int64
+
+	// number of entries returned so far
+	count int
+}
+
+func newScanner(r io.Reader, opts *options) *scanner {
+	rec := &recorder{r: r}
+
+	return &scanner{
+		tr:   tar.NewReader(rec),
+		rec:  rec,
+		opts: opts,
+	}
+}
+
+// Next returns the next entry in the archive.
+func (s *scanner) Next() (*Entry, error) {
+	s.start = s.next
+	s.rec.reset(s.next)
+
+	hdr, err := s.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := parseRawHeader(s.rec.buf)
+	if err != nil {
+		return nil, fmt.Errorf("parsing header at %d: %w: %w", s.next, ErrCorrupt, err)
+	}
+
+	s.count++
+	if err := s.opts.check(hdr, raw, s.count); err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{
+		Header:       *hdr,
+		Offset:       s.rec.n,
+		HeaderOffset: s.next,
+		Filename:     normalize(hdr.Name),
+	}
+
+	entry.Sparse, err = sparseMap(hdr, raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing header at %d: %w: %w", s.next, ErrCorrupt, err)
+	}
+
+	end := s.next + raw.dataEnd()
+	s.next = end + blockPadding(end)
+
+	if s.opts.digests && isRegular(hdr.Typeflag) {
+		// Stop recording so we don't buffer the contents.
+		s.rec.reset(s.next)
+
+		// This reads the logical contents (zero-filling any sparse holes) through the tar.Reader,
+		// which would otherwise have to discard them anyway on the next call to Next.
+		entry.Checksum, err = digest(s.tr)
+		if err != nil {
+			return nil, fmt.Errorf("hashing %q: %w", hdr.Name, err)
+		}
+	}
+
+	return entry, nil
+}
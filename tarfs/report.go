@@ -0,0 +1,96 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorrupt is wrapped by errors for headers that archive/tar accepted but we couldn't make sense of.
+var ErrCorrupt = errors.New("corrupt archive")
+
+// Tolerant makes [New] return an FS with every entry it could read from a truncated or corrupt archive,
+// instead of failing. See [FS.Report] for where and why it stopped.
+//
+// Limits (e.g. [WithMaxEntries]) are still enforced.
+func Tolerant() Option {
+	return func(o *options) {
+		o.tolerant = true
+	}
+}
+
+// Report describes where and why [New] stopped reading an archive early.
+type Report struct {
+	// Offset is where the first entry we couldn't read starts.
+	// Everything before it is available in the FS.
+	Offset int64
+
+	// Entries is the number of entries we read successfully.
+	Entries int
+
+	// Err is why we stopped.
+	Err error
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("stopped reading at %d after %d entries: %v", r.Offset, r.Entries, r.Err)
+}
+
+// Report returns why indexing stopped early in [Tolerant] mode, or nil if the whole archive was read.
+func (fsys *FS) Report() *Report {
+	return fsys.report
+}
+
+// tolerable returns true for errors that come from the archive being truncated or corrupt.
+func tolerable(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, tar.ErrHeader) || errors.Is(err, ErrCorrupt)
+}
+
+// stop records that s failed with err, dropping the last entry if its data was cut off.
+func (fsys *FS) stop(s *scanner, err error) {
+	// Next may have moved on before failing (e.g. while hashing), so start from the entry it was reading.
+	offset := s.start
+
+	if n := len(fsys.files); n != 0 {
+		last := fsys.files[n-1]
+		if last.Offset+last.PhysicalSize() > s.rec.n {
+			offset = last.HeaderOffset
+			fsys.files = fsys.files[:n-1]
+
+			// Point the name back at an earlier entry with the same name, if there was one.
+			delete(fsys.index, last.Filename)
+			for i, e := range fsys.files {
+				if e.Filename == last.Filename {
+					fsys.index[e.Filename] = i
+				}
+			}
+			if fsys.root == last {
+				fsys.root = rootEntry()
+			}
+		}
+	}
+
+	fsys.report = &Report{
+		Offset:  offset,
+		Entries: len(fsys.files),
+		Err:     err,
+	}
+
+	// The archive effectively ends here.
+	fsys.size = offset
+}
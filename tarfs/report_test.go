@@ -0,0 +1,101 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestTolerant(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range []string{"a", "b", "c"} {
+		content := strings.Repeat(name, 1000)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	whole, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whole.Report() != nil {
+		t.Errorf("Report(): want nil for a complete archive, got %v", whole.Report())
+	}
+
+	c, err := whole.Entry("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut off in the middle of c's data.
+	truncated := b[:c.Offset+500]
+
+	// A bad checksum in c's header.
+	corrupt := bytes.Clone(b)
+	copy(corrupt[c.HeaderOffset+148:], "0000000\x00")
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want error
+		opts []Option
+	}{
+		{"truncated", truncated, io.ErrUnexpectedEOF, nil},
+		{"corrupt", corrupt, tar.ErrHeader, nil},
+		// Hashing c fails after the scanner has moved past it.
+		{"digests", truncated, io.ErrUnexpectedEOF, []Option{WithDigests()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(bytes.NewReader(tc.b), int64(len(tc.b)), tc.opts...); !errors.Is(err, tc.want) {
+				t.Fatalf("New: want %v, got %v", tc.want, err)
+			}
+
+			fsys, err := New(bytes.NewReader(tc.b), int64(len(tc.b)), append(tc.opts, Tolerant())...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := fsys.Report()
+			if report == nil {
+				t.Fatal("Report(): want non-nil")
+			}
+			if report.Offset != c.HeaderOffset || report.Entries != 2 || !errors.Is(report.Err, tc.want) {
+				t.Errorf("Report(): got %v", report)
+			}
+			if fsys.Size() != c.HeaderOffset {
+				t.Errorf("Size(): want %d, got %d", c.HeaderOffset, fsys.Size())
+			}
+
+			for _, name := range []string{"a", "b"} {
+				got, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := strings.Repeat(name, 1000); string(got) != want {
+					t.Errorf("ReadFile(%s): got %d bytes", name, len(got))
+				}
+			}
+
+			if _, err := fsys.Entry("c"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Entry(c): want ErrNotExist, got %v", err)
+			}
+		})
+	}
+
+	// Limits are not tolerated.
+	var limit *EntryLimitError
+	if _, err := New(bytes.NewReader(b), int64(len(b)), Tolerant(), WithMaxEntries(1)); !errors.As(err, &limit) {
+		t.Errorf("New(WithMaxEntries(1)): want EntryLimitError, got %v", err)
+	}
+}
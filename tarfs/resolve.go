@@ -0,0 +1,125 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// MaxHops is how many symlinks (or hardlinks) we'll chase before giving up.
+//
+// arbitrary number stolen from filepath.EvalSymlinks
+// this seems to be 40 in linux (MAXSYMLINKS), which might be more reasonable
+const MaxHops = 255
+
+// LookupFunc returns the info for an already-resolved name and, if it's a symlink, its target.
+// A link that isn't empty is what makes it a symlink, so hardlinks have to return "".
+type LookupFunc func(name string) (fi fs.FileInfo, link string, ok bool)
+
+// Resolve walks name one component at a time, the way a kernel would, treating the root
+// of the filesystem as a chroot: ".." never escapes it and absolute symlinks are relative to it.
+// If follow is false, a symlink in the final component is returned as-is (like lstat).
+//
+// This is how [FS] resolves names, exported so that other filesystems built from archives
+// (like an image's merged layers) can resolve names the same way.
+func Resolve(name string, follow bool, lookup LookupFunc) (string, error) {
+	resolved, _, err := walk(name, follow, lookup, nil)
+	return resolved, err
+}
+
+// walk is Resolve, except that it stops at the first entry for which stop returns true (if stop isn't nil),
+// returning that entry's name and the components of name that are left.
+func walk(name string, follow bool, lookup LookupFunc, stop func(fs.FileInfo) bool) (string, []string, error) {
+	resolved := "."
+	rest := strings.Split(name, "/")
+	hops := 0
+
+	for len(rest) != 0 {
+		c := rest[0]
+		rest = rest[1:]
+
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := c
+		if resolved != "." {
+			next = resolved + "/" + c
+		}
+
+		fi, link, ok := lookup(next)
+		if !ok {
+			return "", nil, fs.ErrNotExist
+		}
+
+		if link != "" && (follow || len(rest) != 0) {
+			hops++
+			if hops > MaxHops {
+				return "", nil, fmt.Errorf("resolving %s: chased too many (%d) symlinks", name, MaxHops)
+			}
+
+			if path.IsAbs(link) {
+				resolved = "."
+			}
+
+			rest = append(strings.Split(link, "/"), rest...)
+			continue
+		}
+
+		if stop != nil && stop(fi) {
+			return next, rest, nil
+		}
+
+		if len(rest) != 0 && !fi.IsDir() {
+			// POSIX would say ENOTDIR, but io/fs callers only know about ErrNotExist.
+			return "", nil, fs.ErrNotExist
+		}
+
+		resolved = next
+	}
+
+	return resolved, nil, nil
+}
+
+// ImplicitDir is the fs.FileInfo (and fs.DirEntry) for a directory that has no entry of its own,
+// because it only exists as the parent of something that does.
+type ImplicitDir string
+
+func (d ImplicitDir) Name() string               { return string(d) }
+func (d ImplicitDir) Size() int64                { return 0 }
+func (d ImplicitDir) Mode() fs.FileMode          { return fs.ModeDir | 0o755 }
+func (d ImplicitDir) ModTime() time.Time         { return time.Unix(0, 0) }
+func (d ImplicitDir) IsDir() bool                { return true }
+func (d ImplicitDir) Sys() any                   { return nil }
+func (d ImplicitDir) Type() fs.FileMode          { return fs.ModeDir }
+func (d ImplicitDir) Info() (fs.FileInfo, error) { return d, nil }
+
+// Root is the fs.FileInfo for the root of an archive, which never has an entry of its own that we trust.
+type Root struct{}
+
+func (r Root) Name() string       { return "." }
+func (r Root) Size() int64        { return 0 }
+func (r Root) Mode() fs.FileMode  { return fs.ModeDir }
+func (r Root) ModTime() time.Time { return time.Unix(0, 0) }
+func (r Root) IsDir() bool        { return true }
+func (r Root) Sys() any           { return nil }
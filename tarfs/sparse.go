@@ -0,0 +1,294 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fragment is a region of a sparse file that has data.
+// Everything between fragments is a hole that reads as zeros.
+type Fragment struct {
+	// Offset is the logical offset of this fragment within the file.
+	Offset int64
+
+	// Length is the number of bytes of data in this fragment.
+	// On disk, fragments are stored back to back in order, so the physical offset
+	// of a fragment is the sum of the lengths of every fragment before it.
+	Length int64
+}
+
+const (
+	paxGNUSparseMajor     = "GNU.sparse.major"
+	paxGNUSparseMinor     = "GNU.sparse.minor"
+	paxGNUSparseMap       = "GNU.sparse.map"
+	paxGNUSparseNumBlocks = "GNU.sparse.numblocks"
+
+	// Offsets within the old GNU header and sparse extension blocks.
+	gnuSparseOffset         = 386
+	gnuSparseEntries        = 4
+	gnuIsExtendedOffset     = 482
+	sparseExtEntries        = 21
+	sparseExtIsExtendedOffs = 504
+	sparseEntrySize         = 24
+)
+
+// PhysicalSize returns the number of bytes this entry's data occupies in the archive.
+func (e *Entry) PhysicalSize() int64 {
+	switch e.Header.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		// archive/tar ignores the size for these types.
+		return 0
+	case tar.TypeXGlobalHeader:
+		// archive/tar consumes the records as part of the header, so Offset is already past them.
+		return 0
+	}
+
+	if e.Sparse == nil {
+		return e.Header.Size
+	}
+
+	var n int64
+	for _, frag := range e.Sparse {
+		n += frag.Length
+	}
+	return n
+}
+
+// sparseMap extracts the sparse map for a header, if it has one.
+// This mirrors what archive/tar does internally, since it doesn't expose the map.
+func sparseMap(hdr *tar.Header, raw *rawHeader) ([]Fragment, error) {
+	var (
+		frags []Fragment
+		err   error
+	)
+
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		frags, err = raw.oldGNUSparseMap()
+	} else {
+		major, minor := hdr.PAXRecords[paxGNUSparseMajor], hdr.PAXRecords[paxGNUSparseMinor]
+		switch {
+		case major == "0" && (minor == "0" || minor == "1"):
+			// archive/tar converts 0.0 to 0.1 while parsing PAX records.
+			frags, err = parseSparseMap0x1(hdr.PAXRecords)
+		case major == "1" && minor == "0":
+			frags, err = parseSparseMap1x0(raw.sparseMap1x0())
+		case major != "" || minor != "":
+			// Unknown version, archive/tar treats it as a regular file.
+			return nil, nil
+		case hdr.PAXRecords[paxGNUSparseMap] != "":
+			// 0.0 and 0.1 did not have explicit version records.
+			frags, err = parseSparseMap0x1(hdr.PAXRecords)
+		default:
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if frags == nil {
+		return nil, nil
+	}
+
+	// An empty map means the whole file is a hole, but we need Sparse to be non-empty
+	// to survive Encode and Decode, so add an empty fragment at the end.
+	if len(frags) == 0 {
+		frags = append(frags, Fragment{Offset: hdr.Size})
+	}
+
+	var end int64
+	for _, frag := range frags {
+		if frag.Offset < end || frag.Length < 0 || frag.Offset+frag.Length > hdr.Size {
+			return nil, fmt.Errorf("invalid sparse map for %q: %v", hdr.Name, frags)
+		}
+		end = frag.Offset + frag.Length
+	}
+
+	return frags, nil
+}
+
+func (raw *rawHeader) oldGNUSparseMap() ([]Fragment, error) {
+	frags := []Fragment{}
+
+	blk := raw.main()
+	entries, off, ext := gnuSparseEntries, gnuSparseOffset, gnuIsExtendedOffset
+
+	for i := 0; ; i++ {
+		for j := range entries {
+			entry := blk[off+j*sparseEntrySize:]
+			if entry[0] == 0 {
+				break
+			}
+
+			offset, err := parseNumeric(entry[:12])
+			if err != nil {
+				return nil, err
+			}
+			length, err := parseNumeric(entry[12:24])
+			if err != nil {
+				return nil, err
+			}
+
+			frags = append(frags, Fragment{Offset: offset, Length: length})
+		}
+
+		if blk[ext] == 0 {
+			return frags, nil
+		}
+
+		start := raw.mainOffset + blockSize*(i+1)
+		if start+blockSize > len(raw.buf) {
+			return nil, fmt.Errorf("missing sparse extension header")
+		}
+
+		blk = raw.buf[start : start+blockSize]
+		entries, off, ext = sparseExtEntries, 0, sparseExtIsExtendedOffs
+	}
+}
+
+func parseSparseMap0x1(records map[string]string) ([]Fragment, error) {
+	n, err := strconv.ParseInt(records[paxGNUSparseNumBlocks], 10, 0)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", paxGNUSparseNumBlocks, records[paxGNUSparseNumBlocks])
+	}
+
+	fields := strings.Split(records[paxGNUSparseMap], ",")
+	if len(fields) == 1 && fields[0] == "" {
+		fields = nil
+	}
+
+	return parseSparseFields(fields, n)
+}
+
+func parseSparseMap1x0(b []byte) ([]Fragment, error) {
+	fields := strings.Split(string(b), "\n")
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty sparse map")
+	}
+
+	n, err := strconv.ParseInt(fields[0], 10, 0)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid sparse map entry count: %q", fields[0])
+	}
+
+	if int64(len(fields)-1) < 2*n {
+		return nil, fmt.Errorf("sparse map has %d fields, expected %d", len(fields)-1, 2*n)
+	}
+
+	return parseSparseFields(fields[1:1+2*n], n)
+}
+
+func parseSparseFields(fields []string, n int64) ([]Fragment, error) {
+	if int64(len(fields)) != 2*n {
+		return nil, fmt.Errorf("sparse map has %d fields, expected %d", len(fields), 2*n)
+	}
+
+	frags := make([]Fragment, 0, n)
+	for i := 0; i < len(fields); i += 2 {
+		offset, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sparse offset: %w", err)
+		}
+		length, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sparse length: %w", err)
+		}
+
+		frags = append(frags, Fragment{Offset: offset, Length: length})
+	}
+
+	return frags, nil
+}
+
+// sparseReaderAt presents the logical contents of a sparse file, filling holes with zeros.
+type sparseReaderAt struct {
+	ra    io.ReaderAt
+	frags []Fragment
+
+	// physical offset of each fragment within ra
+	offsets []int64
+}
+
+func newSparseReaderAt(ra io.ReaderAt, offset int64, frags []Fragment) *sparseReaderAt {
+	offsets := make([]int64, len(frags))
+	for i, frag := range frags {
+		offsets[i] = offset
+		offset += frag.Length
+	}
+
+	return &sparseReaderAt{
+		ra:      ra,
+		frags:   frags,
+		offsets: offsets,
+	}
+}
+
+// ReadAt zero-fills forever past the last fragment, so it relies on io.SectionReader to bound it.
+func (s *sparseReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	// Find the first fragment that ends after off.
+	i := sort.Search(len(s.frags), func(i int) bool {
+		return s.frags[i].Offset+s.frags[i].Length > off
+	})
+
+	n := 0
+	for n < len(p) {
+		if i >= len(s.frags) {
+			// Trailing hole.
+			clear(p[n:])
+			return len(p), nil
+		}
+
+		frag := s.frags[i]
+
+		// Leading hole before this fragment.
+		if off < frag.Offset {
+			hole := min(frag.Offset-off, int64(len(p)-n))
+			clear(p[n : n+int(hole)])
+			n += int(hole)
+			off += hole
+			continue
+		}
+
+		want := min(frag.Offset+frag.Length-off, int64(len(p)-n))
+		got, err := s.ra.ReadAt(p[n:n+int(want)], s.offsets[i]+off-frag.Offset)
+		n += got
+		off += int64(got)
+		if int64(got) < want {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+
+		i++
+	}
+
+	return n, nil
+}
+
+// data returns a reader for the logical contents of e.
+func (fsys *FS) data(e *Entry) *io.SectionReader {
+	if e.Sparse == nil {
+		return io.NewSectionReader(fsys.ra, e.Offset, e.Header.Size)
+	}
+
+	return io.NewSectionReader(newSparseReaderAt(fsys.ra, e.Offset, e.Sparse), 0, e.Header.Size)
+}
@@ -0,0 +1,229 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// archive/tar can't write sparse files, so these helpers build raw blocks by hand.
+
+func octal(b []byte, n int64) {
+	s := fmt.Sprintf("%0*o", len(b)-1, n)
+	copy(b, s+"\x00")
+}
+
+func rawBlock(name string, typ byte, size int64, gnu bool, extra func(blk []byte)) []byte {
+	blk := make([]byte, blockSize)
+	copy(blk[0:100], name)
+	octal(blk[100:108], 0o644)
+	octal(blk[108:116], 0)
+	octal(blk[116:124], 0)
+	octal(blk[124:136], size)
+	octal(blk[136:148], 0)
+	blk[156] = typ
+	if gnu {
+		copy(blk[257:265], "ustar  \x00")
+	} else {
+		copy(blk[257:265], "ustar\x0000")
+	}
+	if extra != nil {
+		extra(blk)
+	}
+
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, c := range blk {
+		sum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	return blk
+}
+
+func pad(b []byte) []byte {
+	return append(b, make([]byte, blockPadding(int64(len(b))))...)
+}
+
+func paxHeader(records ...string) []byte {
+	var data []byte
+	for i := 0; i < len(records); i += 2 {
+		// The length prefix counts itself.
+		rec := " " + records[i] + "=" + records[i+1] + "\n"
+		n := len(rec)
+		for n != len(rec)+len(fmt.Sprint(n)) {
+			n = len(rec) + len(fmt.Sprint(n))
+		}
+		data = append(data, fmt.Sprintf("%d%s", n, rec)...)
+	}
+	return append(rawBlock("PaxHeaders/x", tar.TypeXHeader, int64(len(data)), false, nil), pad(data)...)
+}
+
+// sparseFixture is the logical contents of a sparse file and its map.
+type sparseFixture struct {
+	size  int64
+	frags []Fragment
+}
+
+func (sf sparseFixture) logical() []byte {
+	b := make([]byte, sf.size)
+	for i, frag := range sf.frags {
+		for j := range frag.Length {
+			b[frag.Offset+j] = byte('a' + i)
+		}
+	}
+	return b
+}
+
+func (sf sparseFixture) physical() []byte {
+	var b []byte
+	logical := sf.logical()
+	for _, frag := range sf.frags {
+		b = append(b, logical[frag.Offset:frag.Offset+frag.Length]...)
+	}
+	return b
+}
+
+func TestSparse(t *testing.T) {
+	sf := sparseFixture{
+		size: 10000,
+		frags: []Fragment{
+			{Offset: 0, Length: 10},
+			{Offset: 1000, Length: 20},
+			{Offset: 2000, Length: 600},
+			{Offset: 3000, Length: 1},
+			{Offset: 4000, Length: 7},
+			{Offset: 5000, Length: 5},
+		},
+	}
+	physical := sf.physical()
+
+	var archive []byte
+
+	// Old GNU format, which needs an extension block for more than 4 fragments.
+	archive = append(archive, rawBlock("gnu", tar.TypeGNUSparse, int64(len(physical)), true, func(blk []byte) {
+		for i, frag := range sf.frags[:4] {
+			octal(blk[gnuSparseOffset+i*sparseEntrySize:][:12], frag.Offset)
+			octal(blk[gnuSparseOffset+i*sparseEntrySize+12:][:12], frag.Length)
+		}
+		blk[gnuIsExtendedOffset] = 1
+		octal(blk[483:495], sf.size)
+	})...)
+	ext := make([]byte, blockSize)
+	for i, frag := range sf.frags[4:] {
+		octal(ext[i*sparseEntrySize:][:12], frag.Offset)
+		octal(ext[i*sparseEntrySize+12:][:12], frag.Length)
+	}
+	archive = append(archive, ext...)
+	archive = append(archive, pad(physical)...)
+
+	// PAX 0.1 format, where the map is in the PAX records.
+	var fields []string
+	for _, frag := range sf.frags {
+		fields = append(fields, fmt.Sprint(frag.Offset), fmt.Sprint(frag.Length))
+	}
+	archive = append(archive, paxHeader(
+		"GNU.sparse.major", "0",
+		"GNU.sparse.minor", "1",
+		"GNU.sparse.name", "pax01",
+		"GNU.sparse.realsize", fmt.Sprint(sf.size),
+		"GNU.sparse.numblocks", fmt.Sprint(len(sf.frags)),
+		"GNU.sparse.map", strings.Join(fields, ","),
+	)...)
+	archive = append(archive, rawBlock("GNUSparseFile.0/pax01", tar.TypeReg, int64(len(physical)), false, nil)...)
+	archive = append(archive, pad(physical)...)
+
+	// PAX 1.0 format, where the map is at the start of the data.
+	sparseMap := pad([]byte(fmt.Sprintf("%d\n%s\n", len(sf.frags), strings.Join(fields, "\n"))))
+	archive = append(archive, paxHeader(
+		"GNU.sparse.major", "1",
+		"GNU.sparse.minor", "0",
+		"GNU.sparse.name", "pax10",
+		"GNU.sparse.realsize", fmt.Sprint(sf.size),
+	)...)
+	archive = append(archive, rawBlock("GNUSparseFile.0/pax10", tar.TypeReg, int64(len(sparseMap)+len(physical)), false, nil)...)
+	archive = append(archive, sparseMap...)
+	archive = append(archive, pad(physical)...)
+
+	// A regular file after all of that to make sure we kept track of offsets.
+	const after = "still here"
+	archive = append(archive, rawBlock("after", tar.TypeReg, int64(len(after)), false, nil)...)
+	archive = append(archive, pad([]byte(after))...)
+	archive = append(archive, make([]byte, 2*blockSize)...)
+
+	// Make sure archive/tar agrees with our fixture.
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "after" && !bytes.Equal(b, sf.logical()) {
+			t.Fatalf("archive/tar disagrees with fixture for %q", hdr.Name)
+		}
+	}
+
+	ra := bytes.NewReader(archive)
+	fsys, err := New(ra, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toc := &bytes.Buffer{}
+	if err := fsys.Encode(toc); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(ra, toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fsys := range []*FS{fsys, decoded} {
+		for _, name := range []string{"gnu", "pax01", "pax10"} {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != sf.size {
+				t.Errorf("Stat(%q).Size(): want %d, got %d", name, sf.size, info.Size())
+			}
+
+			b, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, sf.logical()) {
+				t.Errorf("ReadFile(%q): mismatched contents", name)
+			}
+
+			f, err := fsys.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, 1500)
+			if _, err := f.(io.ReaderAt).ReadAt(p, 1990); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, sf.logical()[1990:3490]) {
+				t.Errorf("ReadAt(%q): mismatched contents", name)
+			}
+		}
+
+		if b, err := fs.ReadFile(fsys, "after"); err != nil {
+			t.Fatal(err)
+		} else if string(b) != after {
+			t.Errorf("ReadFile(after): want %q, got %q", after, b)
+		}
+	}
+}
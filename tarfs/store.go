@@ -0,0 +1,62 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/jonjohnsonjr/targz/store"
+)
+
+// WithIndexStore makes [New] use the TOC for digest (the digest of the blob the archive came from) from s, if there is one.
+// Otherwise, the TOC is published to s after indexing, unless a [Tolerant] FS stopped early.
+//
+// The store is only a cache, so failing to get from it or put to it isn't an error.
+// Nested archives opened by [FS.OpenArchive] don't use it.
+func WithIndexStore(s store.IndexStore, digest string) Option {
+	return func(o *options) {
+		o.store = s
+		o.digest = digest
+	}
+}
+
+// fromStore decodes the TOC from o's store, if it has one.
+func fromStore(ra io.ReaderAt, o *options) (*FS, bool) {
+	b, err := o.store.Get(o.digest, store.TOC)
+	if err != nil {
+		return nil, false
+	}
+
+	fsys, err := decode(ra, bytes.NewReader(b), o)
+	if err != nil {
+		return nil, false
+	}
+
+	return fsys, true
+}
+
+// publish puts our TOC in the store.
+func (fsys *FS) publish() {
+	if fsys.report != nil {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := fsys.Encode(buf); err != nil {
+		return
+	}
+	fsys.opts.store.Put(fsys.opts.digest, store.TOC, buf.Bytes())
+}
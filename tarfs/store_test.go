@@ -0,0 +1,73 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jonjohnsonjr/targz/store"
+)
+
+// failingReaderAt fails every read, to prove we didn't need to read anything.
+type failingReaderAt struct{}
+
+func (failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("shouldn't be reading")
+}
+
+func TestIndexStore(t *testing.T) {
+	b, err := os.ReadFile("./testdata/gsip.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	st := store.Dir(t.TempDir())
+
+	if _, err := New(bytes.NewReader(b), int64(len(b)), WithIndexStore(st, digest)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(digest, store.TOC); err != nil {
+		t.Fatalf("TOC wasn't published: %v", err)
+	}
+
+	fsys, err := New(failingReaderAt{}, int64(len(b)), WithIndexStore(st, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.ra = bytes.NewReader(b)
+
+	if err := fstest.TestFS(fsys, "gsip/gsip.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tolerant FS that stopped early doesn't publish its partial TOC.
+	const other = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	truncated := b[:len(b)/2+100]
+	fsys, err = New(bytes.NewReader(truncated), int64(len(truncated)), Tolerant(), WithIndexStore(st, other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsys.Report() == nil {
+		t.Fatal("want a report for a truncated tar")
+	}
+	if _, err := st.Get(other, store.TOC); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("partial TOC: want ErrNotFound, got %v", err)
+	}
+}
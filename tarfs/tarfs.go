@@ -25,12 +25,16 @@ import (
 	"io"
 	"io/fs"
 	"iter"
+	"math"
 	"path"
 	"strings"
+	"sync/atomic"
 	"testing/iotest"
-	"time"
 
 	"slices"
+
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/store"
 )
 
 var emptyReader = iotest.ErrReader(io.EOF)
@@ -39,6 +43,18 @@ type Entry struct {
 	Header tar.Header
 	Offset int64
 
+	// HeaderOffset is where the raw header blocks for this entry start.
+	// The bytes between HeaderOffset and Offset are the header (plus any PAX or GNU extensions).
+	HeaderOffset int64 `json:",omitempty"`
+
+	// Sparse is the sparse map for GNU and PAX sparse files, nil otherwise.
+	// The data at Offset is the concatenation of each fragment.
+	Sparse []Fragment `json:",omitempty"`
+
+	// Checksum is the digest of a regular file's contents (e.g. "sha256:..."),
+	// if the archive was indexed with [WithDigests].
+	Checksum string `json:",omitempty"`
+
 	Filename string
 	dir      string
 	fi       fs.FileInfo
@@ -79,10 +95,12 @@ func (f *File) Stat() (fs.FileInfo, error) {
 }
 
 func (f *File) Read(p []byte) (int, error) {
+	f.fsys.recordRead(f.Entry)
 	return f.sr.Read(p)
 }
 
 func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.recordRead(f.Entry)
 	return f.sr.ReadAt(p, off)
 }
 
@@ -99,9 +117,9 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 		return nil, nil
 	}
 
-	dir, err := f.fsys.ReadDir(f.Entry.Filename)
+	dir, err := f.fsys.readDir(f.Entry.Filename)
 	if err != nil {
-		return nil, err
+		return nil, &fs.PathError{Op: "readdir", Path: f.Entry.Filename, Err: err}
 	}
 
 	if f.cursor >= len(dir) {
@@ -132,26 +150,79 @@ type FS struct {
 
 	// Contains real or synthesized entry for "."
 	root *Entry
+
+	// The end of the archive, including the trailer and any padding after it.
+	// Zero if we don't know it (e.g. a TOC from before we kept track of it).
+	size int64
+
+	// Holds a non-nil profiler while recording a Profile.
+	// This is a pointer so that views created by Sub record into the same Profile.
+	prof *atomic.Pointer[profiler]
+
+	// For views created by Sub, the directory (relative to the root of the archive) that this FS is rooted at.
+	prefix string
+
+	// Non-nil if we stopped reading early in tolerant mode.
+	report *Report
+
+	// The options we were created with, which nested archives inherit.
+	opts *options
+
+	// Nested archives we've already indexed, shared with any views created by Sub.
+	nested *nested
+}
+
+// full returns the name within the archive for a name relative to this FS.
+func (fsys *FS) full(name string) string {
+	if fsys.prefix == "" {
+		return name
+	}
+	if name == "." {
+		return fsys.prefix
+	}
+	return fsys.prefix + "/" + name
 }
 
 func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
-	if i, ok := fsys.index[name]; ok {
-		if f := fsys.files[i]; f != nil {
-			return f.fi, nil
-		}
+	if inner, rest, ok, err := fsys.mounted(fsys.full(name)); err != nil {
+		return nil, err
+	} else if ok && rest != "." {
+		return inner.Lstat(rest)
 	}
 
 	// fs.WalkDir expects "." to return a root entry to bootstrap the walk.
-	// If we didn't find it above, synthesize one.
-	if name == "." {
-		return fsys.root.Info()
+	// If the archive doesn't have one, lookup will synthesize one.
+	e, err := fsys.lstat(fsys.full(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Info()
+}
+
+// lstat resolves every component of name except the last one.
+func (fsys *FS) lstat(name string) (*Entry, error) {
+	resolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fs.ErrNotExist
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return e, nil
 }
 
 func (fsys *FS) ReadLink(name string) (string, error) {
-	e, err := fsys.Entry(name)
+	if inner, rest, ok, err := fsys.mounted(fsys.full(name)); err != nil {
+		return "", err
+	} else if ok && rest != "." {
+		return inner.ReadLink(rest)
+	}
+
+	e, err := fsys.lstat(fsys.full(name))
 	if err != nil {
 		return "", err
 	}
@@ -169,72 +240,113 @@ func (fsys *FS) Readlink(name string) (string, error) {
 	return fsys.ReadLink(name)
 }
 
-func dirs(name string) iter.Seq[string] {
-	return func(yield func(string) bool) {
-		for i, v := range name {
-			if v == '/' {
-				if !yield(name[0:i]) {
-					return
-				}
-			}
-		}
+// lookup returns the entry for an already-resolved name.
+// Directories that only exist implicitly (as a parent of some entry) get a synthesized entry.
+func (fsys *FS) lookup(name string) (*Entry, bool) {
+	if name == "." {
+		return fsys.root, true
+	}
+
+	if i, ok := fsys.index[name]; ok {
+		return fsys.files[i], true
 	}
+
+	if _, ok := fsys.dirs[name]; ok {
+		return &Entry{
+			Header: tar.Header{
+				Name:     name,
+				Typeflag: tar.TypeDir,
+				Mode:     0o755,
+			},
+			Filename: name,
+			dir:      path.Dir(name),
+			fi:       ImplicitDir(path.Base(name)),
+		}, true
+	}
+
+	return nil, false
 }
 
-// arbitrary number stolen from filepath.EvalSymlinks
-// this seems to be 40 in linux (MAXSYMLINKS), which might be more reasonable
-const maxHops = 255
+// resolve is [Resolve] for names within the archive.
+func (fsys *FS) resolve(name string, follow bool) (string, error) {
+	return Resolve(name, follow, fsys.lookupLink)
+}
 
-// open follows symlinks up to [maxHops] times.
-func (fsys *FS) open(name string, hops int) (fs.File, error) {
-	if hops > maxHops {
-		return nil, fmt.Errorf("opening %s: chased too many (%d) symlinks", name, maxHops)
+// walk is resolve, except that it stops at the first entry for which stop returns true,
+// returning that entry's name and the components of name that are left.
+func (fsys *FS) walk(name string, follow bool, stop func(fs.FileInfo) bool) (string, []string, error) {
+	return walk(name, follow, fsys.lookupLink, stop)
+}
+
+// lookupLink is lookup as a [LookupFunc].
+func (fsys *FS) lookupLink(name string) (fs.FileInfo, string, bool) {
+	e, ok := fsys.lookup(name)
+	if !ok {
+		return nil, "", false
+	}
+	if e.Header.Typeflag != tar.TypeSymlink {
+		return e.fi, "", true
 	}
+	return e.fi, e.Header.Linkname, true
+}
 
-	e, err := fsys.Entry(name)
+// EvalSymlinks returns the name of the entry that name refers to after following every symlink,
+// similar to filepath.EvalSymlinks but confined to the root of the archive.
+func (fsys *FS) EvalSymlinks(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "evalsymlinks", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := fsys.resolve(fsys.full(name), true)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			// Deal with symlinked dirs.
-			for dir := range dirs(name) {
-				e, err := fsys.Entry(dir)
-				if err != nil {
-					continue
-				}
+		return "", &fs.PathError{Op: "evalsymlinks", Path: name, Err: err}
+	}
 
-				if e.Header.Typeflag != tar.TypeSymlink {
-					continue
-				}
+	if fsys.prefix != "" {
+		if resolved == fsys.prefix {
+			return ".", nil
+		}
 
-				// We need to rewrite what comes after the symlinked dir.
-				rest := strings.TrimPrefix(name, dir)
+		rel, ok := strings.CutPrefix(resolved, fsys.prefix+"/")
+		if !ok {
+			return "", &fs.PathError{Op: "evalsymlinks", Path: name, Err: fmt.Errorf("resolves to %s, outside of %s", resolved, fsys.prefix)}
+		}
+		resolved = rel
+	}
 
-				link := e.Header.Linkname
+	return resolved, nil
+}
 
-				if path.IsAbs(link) {
-					return fsys.open(normalize(path.Join(link, rest)), hops+1)
-				}
+// Realpath is an alias for [FS.EvalSymlinks] for those who think in terms of realpath(3).
+func (fsys *FS) Realpath(name string) (string, error) {
+	return fsys.EvalSymlinks(name)
+}
 
-				return fsys.open(path.Join(e.dir, link, rest), hops+1)
-			}
-		}
+// open resolves name and opens the resulting entry, following hardlinks up to [MaxHops] times.
+func (fsys *FS) open(name string, hops int) (fs.File, error) {
+	if hops > MaxHops {
+		return nil, fmt.Errorf("opening %s: chased too many (%d) hardlinks", name, MaxHops)
+	}
 
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
 		return nil, err
 	}
 
-	switch e.Header.Typeflag {
-	case tar.TypeSymlink, tar.TypeLink:
-		link := e.Header.Linkname
-		if path.IsAbs(link) || e.Header.Typeflag == tar.TypeLink {
-			return fsys.open(normalize(link), hops+1)
-		}
+	e, ok := fsys.lookup(resolved)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
 
-		return fsys.open(path.Join(e.dir, link), hops+1)
+	// Hardlinks are always relative to the root of the archive.
+	if e.Header.Typeflag == tar.TypeLink {
+		return fsys.open(normalize(e.Header.Linkname), hops+1)
 	}
 
 	f := &File{
 		Entry: e,
 		fsys:  fsys,
-		sr:    io.NewSectionReader(fsys.ra, e.Offset, e.Header.Size),
+		sr:    fsys.data(e),
 	}
 
 	return f, nil
@@ -242,7 +354,11 @@ func (fsys *FS) open(name string, hops int) (fs.File, error) {
 
 // Open implements fs.FS.
 func (fsys *FS) Open(name string) (fs.File, error) {
-	if name == "." {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name == "." && fsys.prefix == "" {
 		return &File{
 			Entry: fsys.root,
 			fsys:  fsys,
@@ -250,17 +366,17 @@ func (fsys *FS) Open(name string) (fs.File, error) {
 		}, nil
 	}
 
-	return fsys.open(name, 0)
-}
-
-type root struct{}
+	if inner, rest, ok, err := fsys.mounted(fsys.full(name)); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	} else if ok {
+		if rest == "." {
+			return fsys.openMount(inner, name)
+		}
+		return inner.Open(rest)
+	}
 
-func (r root) Name() string       { return "." }
-func (r root) Size() int64        { return 0 }
-func (r root) Mode() fs.FileMode  { return fs.ModeDir }
-func (r root) ModTime() time.Time { return time.Unix(0, 0) }
-func (r root) IsDir() bool        { return true }
-func (r root) Sys() any           { return nil }
+	return fsys.open(fsys.full(name), 0)
+}
 
 func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 	f, err := fsys.Open(name)
@@ -271,7 +387,32 @@ func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 }
 
 func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
-	dirs, ok := fsys.dirs[name]
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if inner, rest, ok, err := fsys.mounted(fsys.full(name)); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	} else if ok {
+		return inner.ReadDir(rest)
+	}
+
+	dirs, err := fsys.readDir(fsys.full(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return dirs, nil
+}
+
+// readDir lists a directory given its name within the archive.
+func (fsys *FS) readDir(name string) ([]fs.DirEntry, error) {
+	resolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, ok := fsys.dirs[resolved]
 	if !ok {
 		return []fs.DirEntry{}, nil
 	}
@@ -279,35 +420,148 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	return dirs, nil
 }
 
-type countReader struct {
-	r io.Reader
-	n int64
+func newFS(ra io.ReaderAt) *FS {
+	return &FS{
+		ra:     ra,
+		files:  []*Entry{},
+		index:  map[string]int{},
+		dirs:   map[string][]fs.DirEntry{},
+		prof:   &atomic.Pointer[profiler]{},
+		opts:   &options{},
+		nested: &nested{fss: map[string]*mount{}},
+		root:   rootEntry(),
+	}
 }
 
-func (cr *countReader) Read(p []byte) (int, error) {
-	n, err := cr.r.Read(p)
-	cr.n += int64(n)
-	return n, err
+// rootEntry is the entry for "." when the archive doesn't have one of its own.
+func rootEntry() *Entry {
+	return &Entry{
+		dir:      ".",
+		Filename: ".",
+		Header: tar.Header{
+			Name: ".",
+		},
+		fi: Root{},
+	}
 }
 
-func New(ra io.ReaderAt, size int64) (*FS, error) {
-	fsys := &FS{
-		ra:    ra,
-		files: []*Entry{},
-		index: map[string]int{},
-		dirs:  map[string][]fs.DirEntry{},
-		root: &Entry{
-			dir:      ".",
-			Filename: ".",
-			Header: tar.Header{
-				Name: ".",
-			},
-			fi: root{},
-		},
+// add appends an entry to the index, filling in the fields we don't serialize.
+func (fsys *FS) add(entry *Entry) {
+	dir := path.Dir(entry.Filename)
+
+	// If the tar contains a "." entry, we don't want ReadDir() to return itself.
+	if entry.Filename == "." && dir == "." {
+		dir = ""
+	}
+
+	entry.dir = dir
+	entry.fi = entry.Header.FileInfo()
+	if fsys.opts.mount && isArchive(entry) {
+		entry.fi = &mountPoint{FileInfo: entry.fi}
+		fsys.nested.points++
+	}
+
+	fsys.index[entry.Filename] = len(fsys.files)
+	fsys.files = append(fsys.files, entry)
+
+	// If this is the root entry, stash it for later.
+	if dir == "" {
+		fsys.root = entry
 	}
+}
 
+// finish pre-generates the results of ReadDir so we don't allocate a ton if fs.WalkDir calls us.
+// TODO: Consider doing this lazily in a sync.Once the first time we see a ReadDir.
+func (fsys *FS) finish() {
 	// Number of entries in a given directory, so we know how large of a slice to allocate.
 	dirCount := map[string]int{}
+	for _, f := range fsys.files {
+		dirCount[f.dir]++
+	}
+
+	for dir, count := range dirCount {
+		fsys.dirs[dir] = make([]fs.DirEntry, 0, count)
+	}
+
+	for _, f := range fsys.files {
+		fsys.dirs[f.dir] = append(fsys.dirs[f.dir], f)
+	}
+
+	// Some archives omit entries for parent directories, so synthesize them to keep resolution and walks working.
+	implicit := map[string]struct{}{}
+	for _, f := range fsys.files {
+		for dir := f.dir; dir != "." && dir != ""; dir = path.Dir(dir) {
+			if _, ok := fsys.index[dir]; ok {
+				break
+			}
+			if _, ok := implicit[dir]; ok {
+				break
+			}
+			implicit[dir] = struct{}{}
+
+			e, _ := fsys.lookup(dir)
+			fsys.dirs[e.dir] = append(fsys.dirs[e.dir], e)
+		}
+	}
+
+	for _, files := range fsys.dirs {
+		// TODO: Consider lazily sorting each directory the first time it's accessed.
+		slices.SortFunc(files, func(a, b fs.DirEntry) int {
+			return cmp.Compare(a.Name(), b.Name())
+		})
+	}
+}
+
+// Option configures how [New] and [Index] index an archive.
+type Option func(*options)
+
+type options struct {
+	digests bool
+
+	maxEntries    int
+	maxNameLength int
+	maxPAXSize    int64
+
+	tolerant bool
+
+	mount bool
+	gzip  []gsip.Option
+
+	store  store.IndexStore
+	digest string
+}
+
+func makeOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func New(ra io.ReaderAt, size int64, opts ...Option) (*FS, error) {
+	o := makeOptions(opts)
+	if o.store == nil {
+		return build(ra, size, o)
+	}
+
+	if fsys, ok := fromStore(ra, o); ok {
+		return fsys, nil
+	}
+
+	fsys, err := build(ra, size, o)
+	if err != nil {
+		return nil, err
+	}
+	fsys.publish()
+
+	return fsys, nil
+}
+
+// build indexes the archive in ra, for New and nested archives that inherit their options.
+func build(ra io.ReaderAt, size int64, o *options) (*FS, error) {
+	fsys := newFS(ra)
+	fsys.opts = o
 
 	// Assume negative size means caller doesn't know. This could be better.
 	if size < 0 {
@@ -315,68 +569,74 @@ func New(ra io.ReaderAt, size int64) (*FS, error) {
 	}
 
 	r := io.NewSectionReader(ra, 0, size)
-	cr := &countReader{r, 0}
-	tr := tar.NewReader(cr)
+	s := newScanner(r, o)
 
 	// TODO: Do this lazily.
 	for {
-		hdr, err := tr.Next()
+		entry, err := s.Next()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
+			if o.tolerant && tolerable(err) {
+				fsys.stop(s, err)
+				break
+			}
 			return nil, err
 		}
 
-		normalized := normalize(hdr.Name)
-		dir := path.Dir(normalized)
-
-		// If the tar contains a "." entry, we don't want ReadDir() to return itself.
-		if normalized == "." && dir == "." {
-			dir = ""
+		// Hardlinks have no data of their own, so borrow the digest of what they point to.
+		if o.digests && entry.Header.Typeflag == tar.TypeLink {
+			if target, err := fsys.entry(normalize(entry.Header.Linkname)); err == nil {
+				entry.Checksum = target.Checksum
+			}
 		}
 
-		fsys.index[normalized] = len(fsys.files)
+		fsys.add(entry)
+	}
 
-		entry := &Entry{
-			Header:   *hdr,
-			Offset:   cr.n,
-			Filename: normalized,
-			dir:      dir,
-			fi:       hdr.FileInfo(),
+	// Read through the padding after the trailer so we know where the archive ends.
+	// This is usually just a few KB of zeros, so don't bother recording it.
+	if fsys.report == nil {
+		s.rec.reset(math.MaxInt64)
+		end, err := skipPadding(s.rec)
+		if err != nil {
+			return nil, fmt.Errorf("reading trailer: %w", err)
 		}
+		fsys.size = end
+	}
 
-		fsys.files = append(fsys.files, entry)
-
-		// If this is the root entry, stash it for later.
-		if dir == "" {
-			fsys.root = entry
-		}
+	fsys.finish()
 
-		dirCount[dir]++
-	}
+	return fsys, nil
+}
 
-	// Pre-generate the results of ReadDir so we don't allocate a ton if fs.WalkDir calls us.
-	// TODO: Consider doing this lazily in a sync.Once the first time we see a ReadDir.
-	for dir, count := range dirCount {
-		fsys.dirs[dir] = make([]fs.DirEntry, 0, count)
+// Entry returns the entry for name without following any symlinks or hardlinks.
+func (fsys *FS) Entry(name string) (*Entry, error) {
+	e, err := fsys.entry(fsys.full(name))
+	if err != nil {
+		return nil, err
 	}
+	return fsys.view(e), nil
+}
 
-	for _, f := range fsys.files {
-		fsys.dirs[f.dir] = append(fsys.dirs[f.dir], f)
+// view returns e with a Filename relative to the root of fsys, which only differs for views created by [FS.Sub].
+func (fsys *FS) view(e *Entry) *Entry {
+	if fsys.prefix == "" {
+		return e
 	}
 
-	for _, files := range fsys.dirs {
-		// TODO: Consider lazily sorting each directory the first time it's accessed.
-		slices.SortFunc(files, func(a, b fs.DirEntry) int {
-			return cmp.Compare(a.Name(), b.Name())
-		})
+	rel := *e
+	if e.Filename == fsys.prefix {
+		rel.Filename = "."
+	} else {
+		rel.Filename = strings.TrimPrefix(e.Filename, fsys.prefix+"/")
 	}
-
-	return fsys, nil
+	return &rel
 }
 
-func (fsys *FS) Entry(name string) (*Entry, error) {
+// entry is like Entry for a name within the archive.
+func (fsys *FS) entry(name string) (*Entry, error) {
 	i, ok := fsys.index[name]
 	if !ok {
 		return nil, fs.ErrNotExist
@@ -386,36 +646,91 @@ func (fsys *FS) Entry(name string) (*Entry, error) {
 	return e, nil
 }
 
+// Entries yields every entry in archive order, skipping any that were overwritten by a later entry
+// with the same name and global headers, which don't describe a file.
+//
+// For views created by [FS.Sub], only entries beneath that directory are yielded,
+// and their Filename is relative to that directory, so it can be passed to Open.
+func (fsys *FS) Entries() iter.Seq[*Entry] {
+	return func(yield func(*Entry) bool) {
+		for e := range fsys.entries(false) {
+			if !yield(fsys.view(e)) {
+				return
+			}
+		}
+	}
+}
+
+// entries is like [FS.Entries] without [FS.view], so Filename is relative to the root of the archive.
+// It can also include global headers (all of them, since they share a name).
+func (fsys *FS) entries(globals bool) iter.Seq[*Entry] {
+	return func(yield func(*Entry) bool) {
+		for i, e := range fsys.files {
+			if e.Header.Typeflag == tar.TypeXGlobalHeader {
+				if globals && !yield(e) {
+					return
+				}
+				continue
+			}
+
+			if fsys.index[e.Filename] != i {
+				continue
+			}
+
+			if fsys.prefix != "" && !strings.HasPrefix(e.Filename, fsys.prefix+"/") {
+				continue
+			}
+
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Size returns where the archive ends, including the trailer and any padding after it,
+// or zero if we don't know (e.g. for an FS decoded from an old TOC, or one that stopped early).
+func (fsys *FS) Size() int64 {
+	return fsys.size
+}
+
 func (fsys *FS) Encode(w io.Writer) error {
 	toc := TOC{
 		Entries: fsys.files,
+		Size:    fsys.size,
 	}
 
 	return json.NewEncoder(w).Encode(&toc)
 }
 
-func Decode(ra io.ReaderAt, r io.Reader) (*FS, error) {
+// Decode restores an FS from a TOC written by [FS.Encode].
+// Options that affect indexing are ignored, but [WithMounts] still applies.
+func Decode(ra io.ReaderAt, r io.Reader, opts ...Option) (*FS, error) {
+	return decode(ra, r, makeOptions(opts))
+}
+
+func decode(ra io.ReaderAt, r io.Reader, o *options) (*FS, error) {
 	toc := TOC{}
 	if err := json.NewDecoder(r).Decode(&toc); err != nil {
 		return nil, err
 	}
 
-	fsys := &FS{
-		ra:    ra,
-		files: toc.Entries,
-		index: make(map[string]int, len(toc.Entries)),
-	}
-
-	for i, e := range fsys.files {
-		e.fi = e.Header.FileInfo()
-		fsys.index[e.Filename] = i
+	fsys := newFS(ra)
+	fsys.opts = o
+	for _, e := range toc.Entries {
+		fsys.add(e)
 	}
+	fsys.size = toc.Size
+	fsys.finish()
 
 	return fsys, nil
 }
 
 type TOC struct {
 	Entries []*Entry
+
+	// Size of the whole archive, including the trailer.
+	Size int64 `json:",omitempty"`
 }
 
 func normalize(s string) string {
@@ -429,14 +744,19 @@ func normalize(s string) string {
 // This is primarily useful if you don't have an io.ReaderAt implementation handy but still
 // want to know the offsets, for example if you're using something like:
 // https://pkg.go.dev/cloud.google.com/go/storage#ObjectHandle.NewRangeReader
-func Index(r io.Reader) ([]*Entry, error) {
-	var files []*Entry
+func Index(r io.Reader, opts ...Option) ([]*Entry, error) {
+	var (
+		files []*Entry
 
-	cr := &countReader{bufio.NewReaderSize(r, 1<<20), 0}
-	tr := tar.NewReader(cr)
+		// For hardlinks to borrow digests from, like build does.
+		byName = map[string]*Entry{}
+	)
+
+	o := makeOptions(opts)
+	s := newScanner(bufio.NewReaderSize(r, 1<<20), o)
 
 	for {
-		hdr, err := tr.Next()
+		entry, err := s.Next()
 		if errors.Is(err, io.EOF) {
 			break
 		}
@@ -444,16 +764,19 @@ func Index(r io.Reader) ([]*Entry, error) {
 			return nil, err
 		}
 
-		normalized := normalize(hdr.Name)
-		dir := path.Dir(normalized)
+		entry.dir = path.Dir(entry.Filename)
+		entry.fi = entry.Header.FileInfo()
 
-		files = append(files, &Entry{
-			Header:   *hdr,
-			Offset:   cr.n,
-			Filename: normalized,
-			dir:      dir,
-			fi:       hdr.FileInfo(),
-		})
+		if o.digests {
+			if entry.Header.Typeflag == tar.TypeLink {
+				if target, ok := byName[normalize(entry.Header.Linkname)]; ok {
+					entry.Checksum = target.Checksum
+				}
+			}
+			byName[entry.Filename] = entry
+		}
+
+		files = append(files, entry)
 	}
 
 	return files, nil
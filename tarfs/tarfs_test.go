@@ -3,6 +3,7 @@ package tarfs
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
 	"io/fs"
 	"os"
 	"testing"
@@ -110,3 +111,107 @@ func TestSymlinkedDirs(t *testing.T) {
 		}
 	}
 }
+
+func TestResolve(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	tw := tar.NewWriter(buf)
+
+	for _, hdr := range []*tar.Header{
+		{Name: "etc", Typeflag: tar.TypeDir},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg, Size: int64(len("root"))},
+		{Name: "usr", Typeflag: tar.TypeDir},
+		{Name: "usr/etc", Typeflag: tar.TypeDir},
+		{Name: "usr/etc/passwd", Typeflag: tar.TypeReg, Size: int64(len("usr"))},
+		{Name: "usr/lib", Typeflag: tar.TypeDir},
+		{Name: "usr/lib/libc.so", Typeflag: tar.TypeReg, Size: int64(len("libc"))},
+		{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"},
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "../../../../etc/passwd"},
+		{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"},
+		{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "lib/../etc/passwd"},
+		{Name: "usr/lib/up", Typeflag: tar.TypeSymlink, Linkname: "../../lib/libc.so"},
+		{Name: "implicit/dir/file", Typeflag: tar.TypeReg},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Name {
+		case "etc/passwd":
+			tw.Write([]byte("root"))
+		case "usr/etc/passwd":
+			tw.Write([]byte("usr"))
+		case "usr/lib/libc.so":
+			tw.Write([]byte("libc"))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"lib":          "usr/lib",
+		"lib/libc.so":  "usr/lib/libc.so",
+		"up":           "usr/etc/passwd",
+		"lib/up":       "usr/lib/libc.so",
+		"escape":       "etc/passwd",
+		"implicit/dir": "implicit/dir",
+	} {
+		got, err := fsys.EvalSymlinks(name)
+		if err != nil {
+			t.Errorf("EvalSymlinks(%q): %v", name, err)
+		} else if got != want {
+			t.Errorf("EvalSymlinks(%q): want %q, got %q", name, want, got)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"up":     "usr",
+		"escape": "root",
+	} {
+		if b, err := fs.ReadFile(fsys, name); err != nil {
+			t.Errorf("ReadFile(%q): %v", name, err)
+		} else if string(b) != want {
+			t.Errorf("ReadFile(%q): want %q, got %q", name, want, b)
+		}
+	}
+
+	des, err := fs.ReadDir(fsys, "lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(des) != 2 || des[0].Name() != "libc.so" || des[1].Name() != "up" {
+		t.Errorf("ReadDir(lib): want [libc.so up], got %v", des)
+	}
+
+	if des, err := fs.ReadDir(fsys, "implicit"); err != nil {
+		t.Fatal(err)
+	} else if len(des) != 1 || des[0].Name() != "dir" || !des[0].IsDir() {
+		t.Errorf("ReadDir(implicit): want [dir/], got %v", des)
+	}
+
+	if _, err := fsys.Open("loop"); err == nil {
+		t.Errorf("Open(loop): expected error")
+	}
+
+	if _, err := fsys.Open("etc/passwd/nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(etc/passwd/nope): want ErrNotExist, got %v", err)
+	}
+
+	if info, err := fsys.Lstat("lib"); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("Lstat(lib): want symlink, got %v", info.Mode())
+	}
+
+	if info, err := fsys.Stat("lib"); err != nil {
+		t.Fatal(err)
+	} else if !info.IsDir() {
+		t.Errorf("Stat(lib): want dir, got %v", info.Mode())
+	}
+}
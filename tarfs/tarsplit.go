@@ -0,0 +1,271 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"unicode/utf8"
+)
+
+// This implements the JSON lines format from github.com/vbatts/tar-split/tar/storage,
+// which containers/storage uses to reconstruct layer tarballs from unpacked files.
+//
+// The archive is described as a sequence of raw "segments" (header blocks, padding and the trailer)
+// interleaved with "files" that only record a name, size and crc64 of their contents.
+
+const (
+	tarSplitFile    = 1
+	tarSplitSegment = 2
+)
+
+type tarSplitEntry struct {
+	Type     int    `json:"type"`
+	Name     string `json:"name,omitempty"`
+	NameRaw  []byte `json:"name_raw,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Payload  []byte `json:"payload"`
+	Position int    `json:"position"`
+}
+
+func (e *tarSplitEntry) name() string {
+	if len(e.NameRaw) != 0 {
+		return string(e.NameRaw)
+	}
+	return e.Name
+}
+
+var crcTable = crc64.MakeTable(crc64.ISO)
+
+// errNoHeaderOffsets is returned for TOCs that were encoded before we tracked where headers start.
+var errNoHeaderOffsets = errors.New("TOC has no header offsets, it needs to be regenerated")
+
+// RawHeader returns the raw header blocks for e, as they appear in the archive.
+func (fsys *FS) RawHeader(e *Entry) ([]byte, error) {
+	if fsys.size == 0 {
+		return nil, errNoHeaderOffsets
+	}
+
+	b := make([]byte, e.Offset-e.HeaderOffset)
+	if _, err := fsys.ra.ReadAt(b, e.HeaderOffset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// WriteTarSplit writes the metadata needed to reassemble the archive byte-for-byte
+// in the tar-split JSON format. See [Assemble].
+func (fsys *FS) WriteTarSplit(w io.Writer) error {
+	if fsys.size == 0 {
+		return errNoHeaderOffsets
+	}
+
+	enc := json.NewEncoder(w)
+	pos := 0
+	emit := func(e *tarSplitEntry) error {
+		e.Position = pos
+		pos++
+		return enc.Encode(e)
+	}
+
+	segment := func(off, end int64) error {
+		if end == off {
+			return nil
+		}
+
+		b := make([]byte, end-off)
+		if _, err := fsys.ra.ReadAt(b, off); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading segment at %d: %w", off, err)
+		}
+
+		return emit(&tarSplitEntry{Type: tarSplitSegment, Payload: b})
+	}
+
+	var cursor int64
+	for _, e := range fsys.files {
+		if e.Sparse != nil {
+			return fmt.Errorf("tar-split cannot represent sparse file %q", e.Header.Name)
+		}
+
+		// Padding for the previous file and the raw header blocks for this one.
+		if err := segment(cursor, e.Offset); err != nil {
+			return err
+		}
+
+		f := &tarSplitEntry{
+			Type: tarSplitFile,
+			Size: e.PhysicalSize(),
+		}
+		if utf8.ValidString(e.Header.Name) {
+			f.Name = e.Header.Name
+		} else {
+			f.NameRaw = []byte(e.Header.Name)
+		}
+
+		if f.Size > 0 {
+			h := crc64.New(crcTable)
+			if _, err := io.Copy(h, fsys.data(e)); err != nil {
+				return fmt.Errorf("reading %s: %w", e.Filename, err)
+			}
+			f.Payload = h.Sum(nil)
+		}
+
+		if err := emit(f); err != nil {
+			return err
+		}
+
+		cursor = e.Offset + f.Size
+	}
+
+	// Padding for the last file, the trailer, and anything after it.
+	return segment(cursor, fsys.size)
+}
+
+// ReadTarSplit builds an FS from tar-split metadata without scanning ra.
+// The header offsets are recovered by adding up the sizes of each segment and file.
+func ReadTarSplit(ra io.ReaderAt, r io.Reader) (*FS, error) {
+	fsys := newFS(ra)
+
+	dec := json.NewDecoder(r)
+
+	// Bytes we've seen since the end of the last file.
+	var (
+		pending   []byte
+		pos       int64
+		lastFile  int64
+		positions int
+	)
+	for {
+		e := &tarSplitEntry{}
+		if err := dec.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if e.Position != positions {
+			return nil, fmt.Errorf("tar-split entry %d has position %d", positions, e.Position)
+		}
+		positions++
+
+		switch e.Type {
+		case tarSplitSegment:
+			pending = append(pending, e.Payload...)
+			pos += int64(len(e.Payload))
+
+		case tarSplitFile:
+			// The pending bytes are the previous file's padding followed by this file's header.
+			headerOffset := lastFile + blockPadding(lastFile)
+			start := headerOffset - (pos - int64(len(pending)))
+			if start < 0 || start > int64(len(pending)) {
+				return nil, fmt.Errorf("tar-split file %q at %d: header does not fit in preceding segments", e.name(), pos)
+			}
+
+			entry, err := newScanner(bytes.NewReader(pending[start:]), &options{}).Next()
+			if err != nil {
+				return nil, fmt.Errorf("parsing header for %q: %w", e.name(), err)
+			}
+
+			if entry.Offset != int64(len(pending))-start {
+				return nil, fmt.Errorf("header for %q is %d bytes, but the segment has %d", e.name(), entry.Offset, int64(len(pending))-start)
+			}
+			if size := entry.PhysicalSize(); size != e.Size {
+				return nil, fmt.Errorf("header for %q has size %d, but tar-split says %d", e.name(), size, e.Size)
+			}
+
+			entry.HeaderOffset = headerOffset
+			entry.Offset = pos
+			fsys.add(entry)
+
+			pos += e.Size
+			lastFile = pos
+			pending = pending[:0]
+
+		default:
+			return nil, fmt.Errorf("unknown tar-split entry type %d", e.Type)
+		}
+	}
+
+	fsys.size = pos
+	fsys.finish()
+
+	return fsys, nil
+}
+
+// Assemble writes a byte-for-byte copy of the original archive described by tar-split metadata,
+// reading the contents of each file from fsys. The contents are checked against their crc64.
+//
+// fsys can be any fs.FS, e.g. an unpacked layer on disk or a *tarfs.FS.
+func Assemble(w io.Writer, metadata io.Reader, fsys fs.FS) error {
+	dec := json.NewDecoder(metadata)
+	for {
+		e := &tarSplitEntry{}
+		if err := dec.Decode(e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch e.Type {
+		case tarSplitSegment:
+			if _, err := w.Write(e.Payload); err != nil {
+				return err
+			}
+
+		case tarSplitFile:
+			if e.Size == 0 {
+				continue
+			}
+
+			if err := assembleFile(w, fsys, e); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown tar-split entry type %d", e.Type)
+		}
+	}
+}
+
+func assembleFile(w io.Writer, fsys fs.FS, e *tarSplitEntry) error {
+	name := e.name()
+
+	f, err := fsys.Open(normalize(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := crc64.New(crcTable)
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(f, e.Size))
+	if err != nil {
+		return fmt.Errorf("copying %s: %w", name, err)
+	}
+	if n != e.Size {
+		return fmt.Errorf("copying %s: want %d bytes, got %d", name, e.Size, n)
+	}
+
+	if got := h.Sum(nil); !bytes.Equal(got, e.Payload) {
+		return fmt.Errorf("copying %s: crc64 mismatch: want %x, got %x", name, e.Payload, got)
+	}
+
+	return nil
+}
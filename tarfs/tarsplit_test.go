@@ -0,0 +1,148 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTarSplit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, tc := range []struct {
+		hdr     *tar.Header
+		content string
+	}{
+		{hdr: &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: &tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0o644}, content: "hello, tar-split"},
+		{hdr: &tar.Header{Name: "dir/" + strings.Repeat("long", 50), Typeflag: tar.TypeReg, Format: tar.FormatGNU}, content: strings.Repeat("x", 1000)},
+		{hdr: &tar.Header{Name: "dir/pax", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}}, content: "pax"},
+		{hdr: &tar.Header{Name: "dir/empty", Typeflag: tar.TypeReg}},
+		{hdr: &tar.Header{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file"}},
+		{hdr: &tar.Header{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file"}},
+	} {
+		tc.hdr.Size = int64(len(tc.content))
+		if err := tw.WriteHeader(tc.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(tc.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Some tools write more than two zero blocks at the end, which we need to preserve.
+	buf.Write(make([]byte, 3*blockSize))
+
+	b := buf.Bytes()
+	fsys, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &bytes.Buffer{}
+	if err := fsys.WriteTarSplit(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := Assemble(out, bytes.NewReader(meta.Bytes()), fsys); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Fatalf("Assemble: reassembled %d bytes differ from original %d bytes", out.Len(), len(b))
+	}
+
+	imported, err := ReadTarSplit(bytes.NewReader(b), bytes.NewReader(meta.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := fsys.files, imported.files
+	if len(want) != len(got) {
+		t.Fatalf("ReadTarSplit: want %d entries, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i].Header.Name != got[i].Header.Name || want[i].Offset != got[i].Offset || want[i].HeaderOffset != got[i].HeaderOffset {
+			t.Errorf("entry %d: want %q at %d/%d, got %q at %d/%d", i, want[i].Header.Name, want[i].HeaderOffset, want[i].Offset, got[i].Header.Name, got[i].HeaderOffset, got[i].Offset)
+		}
+	}
+
+	hdr, err := imported.RawHeader(got[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := b[want[1].HeaderOffset:want[1].Offset]; !bytes.Equal(hdr, want) {
+		t.Errorf("RawHeader: want %d bytes, got %d", len(want), len(hdr))
+	}
+
+	// Reassembling from the imported FS should work too.
+	out.Reset()
+	if err := Assemble(out, bytes.NewReader(meta.Bytes()), imported); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Fatalf("Assemble(imported): reassembled bytes differ from original")
+	}
+
+	// A mismatched file should fail the crc check.
+	corrupt := bytes.Clone(b)
+	corrupt[bytes.Index(corrupt, []byte("hello, tar-split"))] = 'j'
+	bad, err := New(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Assemble(&bytes.Buffer{}, bytes.NewReader(meta.Bytes()), bad); err == nil || !strings.Contains(err.Error(), "crc64") {
+		t.Errorf("Assemble(corrupt): want crc64 mismatch, got %v", err)
+	}
+}
+
+// brokenReaderAt returns err for anything past the first n bytes.
+type brokenReaderAt struct {
+	b   []byte
+	n   int64
+	err error
+}
+
+func (f *brokenReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > f.n {
+		return 0, f.err
+	}
+	return copy(p, f.b[off:]), nil
+}
+
+func TestTrailer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("hello"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	trailer := int64(buf.Len())
+
+	// Pad out the record like GNU tar does, then add something that isn't part of the archive.
+	buf.Write(make([]byte, recordSize-buf.Len()))
+	buf.WriteString("not a tar")
+	b := buf.Bytes()
+
+	fsys, err := New(bytes.NewReader(b), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fsys.Size(); got != recordSize {
+		t.Errorf("Size() = %d, want %d", got, recordSize)
+	}
+
+	// Errors reading the padding aren't swallowed.
+	boom := errors.New("boom")
+	if _, err := New(&brokenReaderAt{b: b, n: trailer, err: boom}, -1); !errors.Is(err, boom) {
+		t.Errorf("New: want %v, got %v", boom, err)
+	}
+}
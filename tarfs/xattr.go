@@ -0,0 +1,249 @@
+// Copyright 2023 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	paxXattr      = "SCHILY.xattr."
+	paxACLAccess  = "SCHILY.acl.access"
+	paxACLDefault = "SCHILY.acl.default"
+
+	// GNU tar --selinux writes the label as its own record instead of an xattr.
+	paxSELinux = "RHT.security.selinux"
+
+	xattrCapability = "security.capability"
+	xattrSELinux    = "security.selinux"
+)
+
+// PAXRecords returns the raw PAX records for this entry, if any.
+func (e Entry) PAXRecords() map[string]string {
+	return e.Header.PAXRecords
+}
+
+// Xattrs returns the extended attributes stored as SCHILY.xattr.* PAX records, keyed by
+// attribute name (e.g. "security.capability"). Values are raw bytes and may not be valid UTF-8.
+func (e Entry) Xattrs() map[string]string {
+	var xattrs map[string]string
+	for k, v := range e.Header.PAXRecords {
+		name, ok := strings.CutPrefix(k, paxXattr)
+		if !ok {
+			continue
+		}
+
+		if xattrs == nil {
+			xattrs = map[string]string{}
+		}
+		xattrs[name] = v
+	}
+
+	return xattrs
+}
+
+// Xattr returns the value of a single extended attribute.
+func (e Entry) Xattr(name string) (string, bool) {
+	v, ok := e.Header.PAXRecords[paxXattr+name]
+	return v, ok
+}
+
+// SELinuxLabel returns the SELinux context of this entry, if the archive recorded one.
+func (e Entry) SELinuxLabel() (string, bool) {
+	v, ok := e.Xattr(xattrSELinux)
+	if !ok {
+		v, ok = e.Header.PAXRecords[paxSELinux]
+	}
+
+	// The kernel includes the trailing NUL in the xattr value.
+	return strings.TrimSuffix(v, "\x00"), ok
+}
+
+// AccessACL returns the POSIX.1e access ACL in its textual form, as written by star and bsdtar.
+func (e Entry) AccessACL() (string, bool) {
+	v, ok := e.Header.PAXRecords[paxACLAccess]
+	return v, ok
+}
+
+// DefaultACL returns the POSIX.1e default ACL in its textual form, as written by star and bsdtar.
+func (e Entry) DefaultACL() (string, bool) {
+	v, ok := e.Header.PAXRecords[paxACLDefault]
+	return v, ok
+}
+
+func (e Entry) Uid() int {
+	return e.Header.Uid
+}
+
+func (e Entry) Gid() int {
+	return e.Header.Gid
+}
+
+func (e Entry) Uname() string {
+	return e.Header.Uname
+}
+
+func (e Entry) Gname() string {
+	return e.Header.Gname
+}
+
+func (e Entry) Devmajor() int64 {
+	return e.Header.Devmajor
+}
+
+func (e Entry) Devminor() int64 {
+	return e.Header.Devminor
+}
+
+// Capabilities is the decoded form of a security.capability xattr (struct vfs_cap_data).
+type Capabilities struct {
+	// Revision is 1, 2 or 3.
+	Revision int
+
+	// Effective is set if the permitted capabilities are raised into the effective set on exec.
+	Effective bool
+
+	Permitted   uint64
+	Inheritable uint64
+
+	// RootID is the namespace root uid for revision 3 (namespaced) capabilities.
+	RootID uint32
+}
+
+const (
+	vfsCapRevisionMask = 0xFF000000
+	vfsCapEffective    = 0x000001
+
+	vfsCapRevision1 = 0x01000000
+	vfsCapRevision2 = 0x02000000
+	vfsCapRevision3 = 0x03000000
+)
+
+// Capabilities returns the file capabilities of this entry, or nil if it has none.
+func (e Entry) Capabilities() (*Capabilities, error) {
+	v, ok := e.Xattr(xattrCapability)
+	if !ok {
+		return nil, nil
+	}
+
+	return parseCapabilities([]byte(v))
+}
+
+func parseCapabilities(b []byte) (*Capabilities, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("parsing %s: too short (%d bytes)", xattrCapability, len(b))
+	}
+
+	magic := binary.LittleEndian.Uint32(b)
+	caps := &Capabilities{
+		Effective: magic&vfsCapEffective != 0,
+	}
+
+	var words, size int
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision1:
+		caps.Revision, words, size = 1, 1, 12
+	case vfsCapRevision2:
+		caps.Revision, words, size = 2, 2, 20
+	case vfsCapRevision3:
+		caps.Revision, words, size = 3, 2, 24
+	default:
+		return nil, fmt.Errorf("parsing %s: unknown revision %#x", xattrCapability, magic&vfsCapRevisionMask)
+	}
+
+	if len(b) != size {
+		return nil, fmt.Errorf("parsing %s: revision %d should be %d bytes, got %d", xattrCapability, caps.Revision, size, len(b))
+	}
+
+	for i := range words {
+		data := b[4+8*i:]
+		caps.Permitted |= uint64(binary.LittleEndian.Uint32(data[0:])) << (32 * i)
+		caps.Inheritable |= uint64(binary.LittleEndian.Uint32(data[4:])) << (32 * i)
+	}
+
+	if caps.Revision == 3 {
+		caps.RootID = binary.LittleEndian.Uint32(b[20:])
+	}
+
+	return caps, nil
+}
+
+// entry has the same fields as Entry but none of the methods, so we can use it to avoid recursing.
+type entry Entry
+
+// entryJSON is the TOC representation of an Entry.
+//
+// PAX records can hold arbitrary bytes (security.capability is binary), but encoding/json
+// mangles strings that aren't valid UTF-8, so those records are split out as base64.
+type entryJSON struct {
+	*entry
+
+	BinaryPAXRecords map[string][]byte `json:",omitempty"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	out := entry(e)
+
+	// Header.Xattrs is deprecated and derived from PAXRecords, so don't store it twice.
+	out.Header.Xattrs = nil
+
+	var raw map[string][]byte
+	for k, v := range e.Header.PAXRecords {
+		if utf8.ValidString(v) {
+			continue
+		}
+
+		if raw == nil {
+			raw = map[string][]byte{}
+			out.Header.PAXRecords = maps.Clone(e.Header.PAXRecords)
+		}
+
+		raw[k] = []byte(v)
+		delete(out.Header.PAXRecords, k)
+	}
+
+	return json.Marshal(entryJSON{
+		entry:            &out,
+		BinaryPAXRecords: raw,
+	})
+}
+
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	in := entryJSON{
+		entry: (*entry)(e),
+	}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	for k, v := range in.BinaryPAXRecords {
+		if e.Header.PAXRecords == nil {
+			e.Header.PAXRecords = map[string]string{}
+		}
+		e.Header.PAXRecords[k] = string(v)
+	}
+
+	// Restore the deprecated field for anyone still reading it.
+	if xattrs := e.Xattrs(); xattrs != nil {
+		e.Header.Xattrs = xattrs
+	}
+
+	return nil
+}
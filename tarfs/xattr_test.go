@@ -0,0 +1,128 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	// cap_net_bind_service=ep as a revision 2 vfs_cap_data.
+	capability := make([]byte, 20)
+	binary.LittleEndian.PutUint32(capability[0:], vfsCapRevision2|vfsCapEffective)
+	binary.LittleEndian.PutUint32(capability[4:], 1<<10)
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "usr/bin/ping",
+		Typeflag: tar.TypeReg,
+		Uid:      1000,
+		Gid:      1001,
+		Uname:    "jon",
+		Gname:    "users",
+		Format:   tar.FormatPAX,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr.security.capability": string(capability),
+			"SCHILY.xattr.security.selinux":    "system_u:object_r:ping_exec_t:s0\x00",
+			"SCHILY.acl.access":                "user::rwx,group::r-x,other::r-x",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "dev/null",
+		Typeflag: tar.TypeChar,
+		Devmajor: 1,
+		Devminor: 3,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ra := bytes.NewReader(buf.Bytes())
+	fsys, err := New(ra, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, fsys *FS) {
+		e, err := fsys.Entry("usr/bin/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Uid() != 1000 || e.Gid() != 1001 || e.Uname() != "jon" || e.Gname() != "users" {
+			t.Errorf("ownership: got %d:%d (%s:%s)", e.Uid(), e.Gid(), e.Uname(), e.Gname())
+		}
+
+		if got, ok := e.Xattr("security.capability"); !ok || got != string(capability) {
+			t.Errorf("Xattr(security.capability): got %q, %t", got, ok)
+		}
+
+		if got := len(e.Xattrs()); got != 2 {
+			t.Errorf("len(Xattrs()): want 2, got %d", got)
+		}
+
+		caps, err := e.Capabilities()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if caps == nil || caps.Revision != 2 || !caps.Effective || caps.Permitted != 1<<10 || caps.Inheritable != 0 {
+			t.Errorf("Capabilities(): got %+v", caps)
+		}
+
+		if got, ok := e.SELinuxLabel(); !ok || got != "system_u:object_r:ping_exec_t:s0" {
+			t.Errorf("SELinuxLabel(): got %q, %t", got, ok)
+		}
+
+		if got, ok := e.AccessACL(); !ok || got != "user::rwx,group::r-x,other::r-x" {
+			t.Errorf("AccessACL(): got %q, %t", got, ok)
+		}
+
+		if _, ok := e.DefaultACL(); ok {
+			t.Errorf("DefaultACL(): unexpected ACL")
+		}
+
+		dev, err := fsys.Entry("dev/null")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.Devmajor() != 1 || dev.Devminor() != 3 {
+			t.Errorf("dev/null: got %d,%d", dev.Devmajor(), dev.Devminor())
+		}
+		if caps, err := dev.Capabilities(); err != nil || caps != nil {
+			t.Errorf("dev/null Capabilities(): got %v, %v", caps, err)
+		}
+
+		des, err := fs.ReadDir(fsys, "usr/bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(des) != 1 || des[0].Name() != "ping" {
+			t.Errorf("ReadDir(usr/bin): got %v", des)
+		}
+	}
+
+	t.Run("New", func(t *testing.T) {
+		check(t, fsys)
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		toc := &bytes.Buffer{}
+		if err := fsys.Encode(toc); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := Decode(ra, toc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check(t, decoded)
+	})
+}
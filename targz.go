@@ -0,0 +1,295 @@
+// Package targz opens tarballs for random access, wherever they are and however they're compressed.
+//
+// [Open] does what every caller of the lower level packages ends up doing by hand: find out how big
+// the blob is, pick a ranger.Reader or an os.File, sniff the compression to pick gsip or bsip,
+// and index the tar with tarfs, optionally caching the indexes so the next Open is cheap.
+package targz
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jonjohnsonjr/targz/bsip"
+	"github.com/jonjohnsonjr/targz/gsip"
+	"github.com/jonjohnsonjr/targz/ranger"
+	"github.com/jonjohnsonjr/targz/store"
+	"github.com/jonjohnsonjr/targz/tarfs"
+)
+
+// Compression is how a tarball is compressed.
+type Compression int
+
+const (
+	None Compression = iota
+	Gzip
+	BGZF
+	Bzip2
+)
+
+func (c Compression) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case BGZF:
+		return "bgzf"
+	case Bzip2:
+		return "bzip2"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// Sniff guesses the compression from the first few bytes of a blob.
+// It needs [gsip.BGZFHeaderSize] bytes to tell BGZF apart from gzip.
+func Sniff(hdr []byte) Compression {
+	switch {
+	case gsip.IsBGZF(hdr):
+		return BGZF
+	case bytes.HasPrefix(hdr, []byte{0x1f, 0x8b}):
+		return Gzip
+	case len(hdr) >= 4 && bytes.HasPrefix(hdr, []byte("BZh")) && hdr[3] >= '1' && hdr[3] <= '9':
+		return Bzip2
+	}
+	return None
+}
+
+// Option configures [Open].
+type Option func(*options)
+
+type options struct {
+	rt    http.RoundTripper
+	cache store.IndexStore
+	tar   []tarfs.Option
+}
+
+// WithTransport sets the transport for http(s) URLs. The default is http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.rt = rt
+	}
+}
+
+// WithCache stores indexes in dir (as a [store.Dir]) and reuses them on later calls to [Open] for the same blob.
+//
+// Entries are keyed by the path or URL along with its size and modification time (or ETag),
+// so a blob that changes gets a fresh index. Remote blobs without an ETag or Last-Modified aren't cached,
+// since there's no way to tell. Failing to write to dir isn't an error.
+func WithCache(dir string) Option {
+	return WithIndexStore(store.Dir(dir))
+}
+
+// WithIndexStore is [WithCache] for any [store.IndexStore].
+func WithIndexStore(s store.IndexStore) Option {
+	return func(o *options) {
+		o.cache = s
+	}
+}
+
+// WithTarOptions passes opts to [tarfs.New].
+func WithTarOptions(opts ...tarfs.Option) Option {
+	return func(o *options) {
+		o.tar = append(o.tar, opts...)
+	}
+}
+
+// Open indexes the tarball at uri, which is either a local path or an http(s) URL.
+// Remote blobs are read with range requests, so only the parts we need are fetched.
+//
+// The returned func releases anything Open is holding on to (like the file) and should be called
+// once you're done with the FS.
+func Open(ctx context.Context, uri string, opts ...Option) (*tarfs.FS, func() error, error) {
+	o := &options{rt: http.DefaultTransport}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	b, err := openBlob(ctx, uri, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fsys, err := b.index(o)
+	if err != nil {
+		b.Close()
+		return nil, nil, fmt.Errorf("indexing %s: %w", uri, err)
+	}
+
+	return fsys, b.Close, nil
+}
+
+// blob is the thing at a uri, compressed or not.
+type blob struct {
+	ra   io.ReaderAt
+	size int64
+
+	// Identifies this version of the blob for caching, or "" if we can't tell versions apart.
+	key string
+
+	io.Closer
+}
+
+func openBlob(ctx context.Context, uri string, o *options) (*blob, error) {
+	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := (&http.Client{Transport: o.rt}).Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HEAD %s: unexpected status: %s", uri, resp.Status)
+		}
+		if resp.ContentLength < 0 {
+			return nil, fmt.Errorf("HEAD %s: missing Content-Length", uri)
+		}
+
+		// Without either of these, a blob that changed but kept its size would get a stale index.
+		var key string
+		version := resp.Header.Get("ETag")
+		if version == "" {
+			version = resp.Header.Get("Last-Modified")
+		}
+		if version != "" {
+			key = fmt.Sprintf("%s\x00%d\x00%s", uri, resp.ContentLength, version)
+		}
+
+		return &blob{
+			ra:     ranger.New(ctx, uri, o.rt),
+			size:   resp.ContentLength,
+			key:    key,
+			Closer: io.NopCloser(nil),
+		}, nil
+	}
+
+	f, err := os.Open(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(uri)
+	if err != nil {
+		abs = uri
+	}
+
+	return &blob{
+		ra:     f,
+		size:   info.Size(),
+		key:    fmt.Sprintf("%s\x00%d\x00%d", abs, info.Size(), info.ModTime().UnixNano()),
+		Closer: f,
+	}, nil
+}
+
+// digest is what we key b's indexes by in a store.
+// It's a hash of where b came from and which version of it we saw, not of its contents,
+// but it looks like a digest so that any store can use it.
+func (b *blob) digest() string {
+	h := sha256.Sum256([]byte(b.key))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// index decompresses (if necessary) and indexes the tar in b.
+func (b *blob) index(o *options) (*tarfs.FS, error) {
+	hdr := make([]byte, gsip.BGZFHeaderSize)
+	n, err := b.ra.ReadAt(hdr, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	var (
+		ra   = b.ra
+		size = b.size
+
+		zopts []gsip.Option
+		topts = o.tar
+
+		// A bsip index that wasn't in the store, to put there once the tar has been read,
+		// since bsip doesn't know about stores.
+		bz *bsip.Reader
+
+		cache = o.cache
+	)
+	if b.key == "" {
+		cache = nil
+	}
+	if cache != nil {
+		zopts = append(zopts, gsip.WithIndexStore(cache, b.digest()))
+		topts = append(slices.Clip(topts), tarfs.WithIndexStore(cache, b.digest()))
+	}
+
+	switch c := Sniff(hdr[:n]); c {
+	case Gzip, BGZF:
+		var zr *gsip.Reader
+		if c == BGZF {
+			zr, err = gsip.NewBGZFReader(b.ra, b.size, zopts...)
+		} else {
+			zr, err = gsip.NewReader(b.ra, b.size, zopts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// We don't know the uncompressed size.
+		ra, size = zr, -1
+	case Bzip2:
+		zr, cached, err := b.bsip(cache)
+		if err != nil {
+			return nil, err
+		}
+		if !cached {
+			bz = zr
+		}
+
+		ra, size = zr, -1
+	}
+
+	fsys, err := tarfs.New(ra, size, topts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// This is only a cache, so don't fail if we can't write it.
+	if bz != nil && cache != nil {
+		buf := &bytes.Buffer{}
+		if err := bz.Encode(buf); err == nil {
+			cache.Put(b.digest(), store.Bsip, buf.Bytes())
+		}
+	}
+
+	return fsys, nil
+}
+
+// bsip opens the bzip2 stream in b, with the index from cache if it has one.
+func (b *blob) bsip(cache store.IndexStore) (*bsip.Reader, bool, error) {
+	if cache != nil {
+		if idx, err := cache.Get(b.digest(), store.Bsip); err == nil {
+			if zr, err := bsip.Decode(b.ra, b.size, bytes.NewReader(idx)); err == nil {
+				return zr, true, nil
+			}
+		}
+	}
+
+	zr, err := bsip.NewReader(b.ra, b.size)
+	return zr, false, err
+}
@@ -1,17 +1,21 @@
-package main
+package targz
 
 import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"io"
 	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/jonjohnsonjr/targz/gsip"
 	"github.com/jonjohnsonjr/targz/ranger"
@@ -122,3 +126,167 @@ func TestNoTarTrailer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// bgzf recompresses b as BGZF blocks.
+func bgzf(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for len(b) > 0 {
+		n := min(len(b), 0xff00)
+
+		var member bytes.Buffer
+		zw := gzip.NewWriter(&member)
+		zw.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		zw.Write(b[:n])
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		mb := member.Bytes()
+		binary.LittleEndian.PutUint16(mb[16:18], uint16(len(mb)-1))
+		buf.Write(mb)
+		b = b[n:]
+	}
+	return buf.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	plain, err := os.ReadFile("./tarfs/testdata/gsip.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for name, b := range map[string][]byte{
+		"gsip.tar":  plain,
+		"gsip.bgzf": bgzf(t, plain),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"tarfs/testdata/gsip.tar.gz", "bsip/testdata/gsip.tar.bz2"} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path.Base(name)), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := tarfs.New(bytes.NewReader(plain), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(http.FileServerFS(os.DirFS(dir)))
+	defer s.Close()
+
+	for name, c := range map[string]Compression{
+		"gsip.tar":     None,
+		"gsip.tar.gz":  Gzip,
+		"gsip.bgzf":    BGZF,
+		"gsip.tar.bz2": Bzip2,
+	} {
+		hdr := make([]byte, gsip.BGZFHeaderSize)
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.ReadAt(hdr, 0)
+		f.Close()
+		if got := Sniff(hdr); got != c {
+			t.Errorf("Sniff(%s): got %s, want %s", name, got, c)
+		}
+
+		for _, uri := range []string{filepath.Join(dir, name), s.URL + "/" + name} {
+			t.Run(uri, func(t *testing.T) {
+				cache := t.TempDir()
+
+				// The second time around should come from the cache.
+				for i := range 2 {
+					fsys, closer, err := Open(context.Background(), uri, WithTransport(s.Client().Transport), WithCache(cache))
+					if err != nil {
+						t.Fatal(err)
+					}
+					compare(t, want, fsys)
+					if err := closer(); err != nil {
+						t.Fatal(err)
+					}
+
+					entries, err := os.ReadDir(filepath.Join(cache, "sha256"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantEntries := 2
+					if c == None {
+						wantEntries = 1
+					}
+					if len(entries) != wantEntries {
+						t.Errorf("Open #%d: got %d cached indexes, want %d", i, len(entries), wantEntries)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestOpenUnversioned(t *testing.T) {
+	b, err := os.ReadFile("tarfs/testdata/gsip.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero modtime means no Last-Modified, and ServeContent never sets an ETag.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "gsip.tar.gz", time.Time{}, bytes.NewReader(b))
+	}))
+	defer s.Close()
+
+	cache := t.TempDir()
+	fsys, closer, err := Open(context.Background(), s.URL, WithTransport(s.Client().Transport), WithCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d cached indexes for a blob without an ETag or Last-Modified, want 0", len(entries))
+	}
+}
+
+// compare checks that every file in got matches want.
+func compare(t *testing.T, want, got fs.FS) {
+	t.Helper()
+
+	if err := fs.WalkDir(want, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		b1, err := fs.ReadFile(want, p)
+		if err != nil {
+			return err
+		}
+		b2, err := fs.ReadFile(got, p)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Errorf("mismatched contents: %q", p)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}